@@ -0,0 +1,409 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The admin UI is plain HTML forms, so it works without JavaScript. It
+// signs in with HTTP Basic auth using the -admin-token as the password and
+// acts on the tenant the request resolves to, like the API.
+
+//go:embed admin
+var adminFiles embed.FS
+
+var adminFuncs = template.FuncMap{
+	"date":       func(t time.Time) string { return t.Local().Format("2006-01-02 15:04") },
+	"size":       formatSize,
+	"pathescape": url.PathEscape,
+}
+
+var adminPages = parseAdminPages("users.html", "user_form.html", "uploads.html")
+
+func parseAdminPages(names ...string) map[string]*template.Template {
+	pages := map[string]*template.Template{}
+	for _, name := range names {
+		pages[name] = template.Must(template.New("layout.html").Funcs(adminFuncs).ParseFS(adminFiles, "admin/layout.html", "admin/"+name))
+	}
+	return pages
+}
+
+var adminPerPageOptions = []int{10, 20, 50, 100}
+
+// adminPage is what every admin template receives
+type adminPage struct {
+	Title  string
+	Tenant string
+	Flash  *adminFlash
+	CSRF   string
+	Data   any
+}
+
+type adminFlash struct {
+	Kind    string // success or error
+	Message string
+}
+
+type adminPagination struct {
+	Number, PerPage, Total, Pages int
+}
+
+func (p adminPagination) HasPrev() bool { return p.Number > 1 }
+func (p adminPagination) HasNext() bool { return p.Number < p.Pages }
+func (p adminPagination) Prev() int     { return p.Number - 1 }
+func (p adminPagination) Next() int     { return p.Number + 1 }
+
+// userForm holds the submitted values and per-field errors of the user form
+type userForm struct {
+	Action        string
+	Name          string
+	Email         string
+	Errors        map[string]string
+	MaxNameLength int
+}
+
+func (f *userForm) validate() bool {
+	f.Errors = map[string]string{}
+	if err := validateName(f.Name); err != nil {
+		f.Errors["name"] = err.Error()
+	}
+	if err := validateEmail(f.Email); err != nil {
+		f.Errors["email"] = err.Error()
+	}
+	return len(f.Errors) == 0
+}
+
+const (
+	adminCSRFCookie  = "admin_csrf"
+	adminFlashCookie = "admin_flash"
+)
+
+// adminUI checks the admin password and, for form posts, the CSRF token
+func adminUI(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+
+		if adminToken == "" {
+			http.Error(w, "The admin UI is disabled, start the server with -admin-token", http.StatusForbidden)
+			logEndpoint(r, startTime, http.StatusForbidden)
+			return
+		}
+		_, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="myAPI admin", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			logEndpoint(r, startTime, http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			cookie, err := r.Cookie(adminCSRFCookie)
+			if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue("csrf"))) != 1 {
+				http.Error(w, "The form has expired, go back and reload the page", http.StatusForbidden)
+				logEndpoint(r, startTime, http.StatusForbidden)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// renderAdmin writes a page, issuing a CSRF token and consuming the flash message
+func renderAdmin(w http.ResponseWriter, r *http.Request, status int, name, title string, data any) {
+	page := adminPage{Title: title, Tenant: tenantFrom(r.Context()).ID, Data: data}
+
+	if cookie, err := r.Cookie(adminCSRFCookie); err == nil && cookie.Value != "" {
+		page.CSRF = cookie.Value
+	} else {
+		page.CSRF = randomHex(16)
+		http.SetCookie(w, &http.Cookie{Name: adminCSRFCookie, Value: page.CSRF, Path: "/admin/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	}
+
+	if cookie, err := r.Cookie(adminFlashCookie); err == nil {
+		if raw, err := base64.RawURLEncoding.DecodeString(cookie.Value); err == nil {
+			kind, message, _ := strings.Cut(string(raw), ":")
+			page.Flash = &adminFlash{Kind: kind, Message: message}
+		}
+		http.SetCookie(w, &http.Cookie{Name: adminFlashCookie, Path: "/admin/", MaxAge: -1})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := adminPages[name].Execute(w, page); err != nil {
+		fmt.Printf("Error rendering admin page %s: %s\n", name, err)
+	}
+}
+
+// redirectWithFlash finishes a form post, showing message on the next page
+func redirectWithFlash(w http.ResponseWriter, r *http.Request, startTime time.Time, target, kind, message string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminFlashCookie,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(kind + ":" + message)),
+		Path:     "/admin/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, target, http.StatusSeeOther)
+	logEndpoint(r, startTime, http.StatusSeeOther)
+}
+
+// Admin home handler function
+func handleAdminHome(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.URL.Path != "/admin/" {
+		http.NotFound(w, r)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusFound)
+	logEndpoint(r, startTime, http.StatusFound)
+}
+
+// Admin user list handler function
+func handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	records, err := storeFor(r).List(r.Context())
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+
+	page := adminPagination{Number: 1, PerPage: adminPerPageOptions[1], Total: len(records)}
+	if n, err := strconv.Atoi(r.URL.Query().Get("per_page")); err == nil {
+		for _, option := range adminPerPageOptions {
+			if n == option {
+				page.PerPage = n
+			}
+		}
+	}
+	page.Pages = max(1, (page.Total+page.PerPage-1)/page.PerPage)
+	if n, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil {
+		page.Number = min(max(n, 1), page.Pages)
+	}
+	start := (page.Number - 1) * page.PerPage
+	end := min(start+page.PerPage, page.Total)
+
+	renderAdmin(w, r, http.StatusOK, "users.html", "Users", map[string]any{
+		"Users":          records[start:end],
+		"Page":           page,
+		"PerPageOptions": adminPerPageOptions,
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// Admin new user form handler function
+func handleAdminNewUser(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	renderAdmin(w, r, http.StatusOK, "user_form.html", "New user", &userForm{Action: "/admin/users", MaxNameLength: maxNameLength})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// Admin create user handler function
+func handleAdminCreateUser(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	form := &userForm{
+		Action:        "/admin/users",
+		Name:          strings.TrimSpace(r.PostFormValue("name")),
+		Email:         strings.TrimSpace(r.PostFormValue("email")),
+		MaxNameLength: maxNameLength,
+	}
+	if !form.validate() {
+		renderAdmin(w, r, http.StatusUnprocessableEntity, "user_form.html", "New user", form)
+		logEndpoint(r, startTime, http.StatusUnprocessableEntity)
+		return
+	}
+
+	added, err := storeFor(r).Add(r.Context(), User{Name: form.Name, Email: form.Email})
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if err != nil {
+		form.Errors["form"] = err.Error()
+		renderAdmin(w, r, http.StatusForbidden, "user_form.html", "New user", form)
+		logEndpoint(r, startTime, http.StatusForbidden)
+		return
+	}
+	saveUsersToFile(r.Context())
+	requestVerification(r, added[0])
+	publishEvent(r.Context(), EventUserCreated, added[0].V1())
+
+	redirectWithFlash(w, r, startTime, "/admin/users", "success", fmt.Sprintf("Created user %s", form.Name))
+}
+
+// Admin edit user form handler function, for /admin/users/{id}/edit
+func handleAdminEditUser(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	id, ok := strings.CutSuffix(getUserIDFromURL(r.URL.Path, "/admin/users/"), "/edit")
+	if !ok {
+		http.NotFound(w, r)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+	record, err := storeFor(r).Get(r.Context(), id)
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if err != nil {
+		redirectWithFlash(w, r, startTime, "/admin/users", "error", "That user no longer exists")
+		return
+	}
+
+	renderAdmin(w, r, http.StatusOK, "user_form.html", "Edit "+record.Name, &userForm{
+		Action:        "/admin/users/" + record.PublicID,
+		Name:          record.Name,
+		Email:         record.Email,
+		MaxNameLength: maxNameLength,
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// Admin user form post handler function: /admin/users/{id} saves the edit
+// form and /admin/users/{id}/delete deletes the user
+func handleAdminUserAction(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	id := getUserIDFromURL(r.URL.Path, "/admin/users/")
+	if id, ok := strings.CutSuffix(id, "/delete"); ok {
+		removed, err := storeFor(r).Delete(r.Context(), id)
+		if requestAborted(w, r, startTime, err) {
+			return
+		}
+		if err != nil {
+			redirectWithFlash(w, r, startTime, "/admin/users", "error", "That user no longer exists")
+			return
+		}
+		saveUsersToFile(r.Context())
+		publishEvent(r.Context(), EventUserDeleted, removed.V1())
+		redirectWithFlash(w, r, startTime, "/admin/users", "success", fmt.Sprintf("Deleted user %s", removed.Name))
+		return
+	}
+	if strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+
+	form := &userForm{
+		Action:        "/admin/users/" + id,
+		Name:          strings.TrimSpace(r.PostFormValue("name")),
+		Email:         strings.TrimSpace(r.PostFormValue("email")),
+		MaxNameLength: maxNameLength,
+	}
+	if !form.validate() {
+		renderAdmin(w, r, http.StatusUnprocessableEntity, "user_form.html", "Edit user", form)
+		logEndpoint(r, startTime, http.StatusUnprocessableEntity)
+		return
+	}
+
+	record, err := storeFor(r).Update(r.Context(), id, User{Name: form.Name, Email: form.Email})
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if err != nil {
+		redirectWithFlash(w, r, startTime, "/admin/users", "error", "That user no longer exists")
+		return
+	}
+	saveUsersToFile(r.Context())
+	requestVerification(r, record)
+	publishEvent(r.Context(), EventUserUpdated, record.V1())
+
+	redirectWithFlash(w, r, startTime, "/admin/users", "success", fmt.Sprintf("Saved user %s", record.Name))
+}
+
+// Admin upload list handler function
+func handleAdminUploads(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	entries, err := os.ReadDir(tenantFrom(r.Context()).uploads)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logEndpoint(r, startTime, http.StatusInternalServerError)
+		return
+	}
+
+	var files []fs.FileInfo
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	renderAdmin(w, r, http.StatusOK, "uploads.html", "Uploads", files)
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// Admin upload download handler function
+func handleAdminDownload(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	name := getUserIDFromURL(r.URL.Path, "/admin/uploads/")
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+	file, err := os.Open(filepath.Join(tenantFrom(r.Context()).uploads, name))
+	if err != nil {
+		http.NotFound(w, r)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(name)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, info.ModTime(), file)
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// adminUIRoutes serve HTML, so unlike apiRoutes they are not in the OpenAPI document
+func adminUIRoutes() []Route {
+	return []Route{
+		{http.MethodGet, "/admin/", adminUI(handleAdminHome)},
+		{http.MethodGet, "/admin/users", adminUI(handleAdminUsers)},
+		{http.MethodPost, "/admin/users", adminUI(handleAdminCreateUser)},
+		{http.MethodGet, "/admin/users/new", adminUI(handleAdminNewUser)},
+		{http.MethodGet, "/admin/users/", adminUI(handleAdminEditUser)},
+		{http.MethodPost, "/admin/users/", adminUI(handleAdminUserAction)},
+		{http.MethodGet, "/admin/uploads", adminUI(handleAdminUploads)},
+		{http.MethodGet, "/admin/uploads/", adminUI(handleAdminDownload)},
+	}
+}
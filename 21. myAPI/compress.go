@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Compression settings
+var (
+	compressionThreshold = 1024     // smallest response body worth compressing
+	maxDecompressedBody  = 32 << 20 // cap on a decompressed request body
+)
+
+var (
+	errDecompressedTooLarge = errors.New("decompressed request body is too large")
+	errUnsupportedEncoding  = errors.New("unsupported Content-Encoding")
+)
+
+// Response types that are already compressed, or must not be buffered
+var incompressibleTypes = []string{
+	"image/", "video/", "audio/", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2",
+	"application/x-xz", "application/x-7z-compressed", "application/x-rar-compressed",
+	"application/zstd", "application/pdf", "application/octet-stream",
+	"text/event-stream",
+}
+
+// withCompression decompresses gzip or deflate request bodies and compresses
+// responses for clients that accept it
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := decompressRequest(r); err != nil {
+			// An encoding we do not know is 415; a body that is not valid
+			// in the encoding it claims is the client's mistake
+			status := http.StatusBadRequest
+			if errors.Is(err, errUnsupportedEncoding) {
+				status = http.StatusUnsupportedMediaType
+			}
+			http.Error(w, err.Error(), status)
+			logEndpoint(r, time.Now(), status)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := chooseEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || isWebSocketRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// chooseEncoding picks gzip or deflate from Accept-Encoding, preferring gzip on a tie
+func chooseEncoding(header string) string {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		weights[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range []string{"gzip", "deflate"} {
+		q, ok := weights[encoding]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+func decompressRequest(r *http.Request) error {
+	var body io.ReadCloser
+	var err error
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		body, err = gzip.NewReader(r.Body)
+	case "deflate":
+		body, err = zlib.NewReader(r.Body)
+	default:
+		return fmt.Errorf("%w %q", errUnsupportedEncoding, encoding)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s request body: %w", r.Header.Get("Content-Encoding"), err)
+	}
+
+	r.Body = &limitedBody{ReadCloser: body, original: r.Body, remaining: int64(maxDecompressedBody)}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+// limitedBody stops a decompression bomb by failing once the limit is passed
+type limitedBody struct {
+	io.ReadCloser
+	original  io.Closer
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Only fail if there really is more data beyond the limit
+		var probe [1]byte
+		if n, _ := b.ReadCloser.Read(probe[:]); n > 0 {
+			return 0, errDecompressedTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	b.ReadCloser.Close()
+	return b.original.Close()
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// body is big enough, and of a suitable type, to be worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	zw       io.WriteCloser // nil when passing the body through
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		return
+	}
+	// Informational responses such as 103 Early Hints go out straight
+	// away and are followed by the real status
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.passThrough()
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if !cw.compressible() {
+			cw.passThrough()
+		} else {
+			cw.buf = append(cw.buf, p...)
+			if len(cw.buf) >= compressionThreshold {
+				if err := cw.startCompression(); err != nil {
+					return 0, err
+				}
+			}
+			return len(p), nil
+		}
+	}
+	if cw.zw != nil {
+		return cw.zw.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// compressible reports whether the response headers allow compression.
+// Partial content is left alone: its Content-Range counts bytes of the
+// uncompressed representation.
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" || cw.status == http.StatusPartialContent {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	for _, prefix := range incompressibleTypes {
+		if mediaType == prefix || (strings.HasSuffix(prefix, "/") && strings.HasPrefix(mediaType, prefix)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cw *compressWriter) startCompression() error {
+	cw.decided = true
+	header := cw.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	header.Set("Content-Encoding", cw.encoding)
+	header.Del("Content-Length")
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if cw.encoding == "gzip" {
+		cw.zw = gzip.NewWriter(cw.ResponseWriter)
+	} else {
+		cw.zw = zlib.NewWriter(cw.ResponseWriter)
+	}
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.zw.Write(buf)
+	return err
+}
+
+func (cw *compressWriter) passThrough() {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) > 0 {
+		cw.ResponseWriter.Write(cw.buf)
+		cw.buf = nil
+	}
+}
+
+// Flush starts compressing right away, since the handler is streaming
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.compressible() {
+			cw.startCompression()
+		} else {
+			cw.passThrough()
+		}
+	}
+	if flusher, ok := cw.zw.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		cw.passThrough()
+	}
+	if cw.zw != nil {
+		return cw.zw.Close()
+	}
+	return nil
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
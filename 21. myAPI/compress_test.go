@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+var largeBody = strings.Repeat("compress me ", 200)
+
+func TestCompressionSkipsPartialContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"206", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, largeBody)
+		}},
+		{"Content-Range", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Range", "bytes 0-2399/5000")
+			io.WriteString(w, largeBody)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			withCompression(tt.handler).ServeHTTP(w, req)
+			if encoding := w.Header().Get("Content-Encoding"); encoding != "" {
+				t.Errorf("partial content was sent with Content-Encoding %s", encoding)
+			}
+			if w.Body.String() != largeBody {
+				t.Error("the body was changed")
+			}
+		})
+	}
+}
+
+func TestCompressionForwardsInformationalResponses(t *testing.T) {
+	server := httptest.NewServer(withCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, largeBody)
+	})))
+	defer server.Close()
+
+	var informational []int
+	trace := &httptrace.ClientTrace{Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+		informational = append(informational, code)
+		return nil
+	}}
+	req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, server.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if len(informational) != 1 || informational[0] != http.StatusEarlyHints {
+		t.Errorf("informational responses %v, want [103]", informational)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("final status %d, want 201", resp.StatusCode)
+	}
+	// the client asked for gzip implicitly and decoded it
+	if !resp.Uncompressed || string(body) != largeBody {
+		t.Errorf("body was not compressed and decoded: uncompressed=%v, %d bytes", resp.Uncompressed, len(body))
+	}
+}
+
+func TestDecompressRequestStatus(t *testing.T) {
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	io.WriteString(zw, `{"name":"Ada"}`)
+	zw.Close()
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+	}{
+		{"valid gzip", "gzip", gzipped.Bytes(), http.StatusOK},
+		{"not gzip", "gzip", []byte("plain text"), http.StatusBadRequest},
+		{"not deflate", "deflate", []byte("plain text"), http.StatusBadRequest},
+		{"unknown encoding", "br", []byte("anything"), http.StatusUnsupportedMediaType},
+	}
+	handler := withCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Encoding", tt.encoding)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
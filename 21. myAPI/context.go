@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Request deadlines. Routes not listed in routeTimeouts get
+// defaultRequestTimeout; zero means no deadline.
+var defaultRequestTimeout = 30 * time.Second
+
+// registerTimeoutFlags adds the deadline flags to fs
+func registerTimeoutFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&defaultRequestTimeout, "request-timeout", defaultRequestTimeout, "deadline for requests to routes without their own")
+}
+
+var routeTimeouts = map[string]time.Duration{
+	"/api/upload":       5 * time.Minute,
+	"/api/users/import": 5 * time.Minute,
+	"/api/users/export": 5 * time.Minute,
+	"/api/events":       0, // long-lived streams
+}
+
+// nginx's status for a request the client gave up on; it only shows in logs
+const statusClientClosedRequest = 499
+
+// routeTimeout looks up the deadline of a route; /api/v1 aliases share
+// the deadline of their unversioned route
+func routeTimeout(pattern string) time.Duration {
+	if rest, ok := strings.CutPrefix(pattern, "/api/v1/"); ok {
+		pattern = "/api/" + rest
+	}
+	if timeout, ok := routeTimeouts[pattern]; ok {
+		return timeout
+	}
+	return defaultRequestTimeout
+}
+
+// withDeadline bounds a request by timeout. The request context ends at
+// the deadline and so do reads of the request body, which the context
+// alone would not interrupt.
+func withDeadline(timeout time.Duration, handler http.HandlerFunc) http.HandlerFunc {
+	if timeout <= 0 {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		deadline := time.Now().Add(timeout)
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+
+		http.NewResponseController(w).SetReadDeadline(deadline)
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// requestAborted handles err when it was caused by the request ending:
+// the client went away or the deadline passed. It answers and logs the
+// request and returns true; for any other error it does nothing.
+func requestAborted(w http.ResponseWriter, r *http.Request, startTime time.Time, err error) bool {
+	if err == nil {
+		return false
+	}
+	ctxErr := r.Context().Err()
+	switch {
+	case errors.Is(ctxErr, context.Canceled):
+		// Nobody is listening, the status is for the log
+		http.Error(w, "Client closed request", statusClientClosedRequest)
+		logCancelled(r, startTime, statusClientClosedRequest, "client disconnected")
+	case errors.Is(ctxErr, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		http.Error(w, "Request timed out", http.StatusServiceUnavailable)
+		logCancelled(r, startTime, http.StatusServiceUnavailable, "deadline exceeded")
+	default:
+		return false
+	}
+	return true
+}
+
+// logCancelled is logEndpoint for requests that were abandoned rather than failed
+func logCancelled(r *http.Request, startTime time.Time, statusCode int, reason string) {
+	fmt.Printf("\n=== Request Cancelled ===\n")
+	fmt.Printf("Timestamp: %v\n", time.Now().Format("2006-01-02 15:04:05"))
+	fmt.Printf("Method: %s\n", r.Method)
+	fmt.Printf("Endpoint: %s\n", r.URL.Path)
+	fmt.Printf("Reason: %s\n", reason)
+	fmt.Printf("Status: %d\n", statusCode)
+	fmt.Printf("Duration: %v\n", time.Since(startTime))
+	fmt.Printf("Client IP: %s\n", r.RemoteAddr)
+	fmt.Printf("=========================\n")
+}
+
+// contextReader stops a copy between reads once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+func diskFree(path string) (uint64, error) {
+	return 0, errDiskFreeUnsupported
+}
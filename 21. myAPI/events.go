@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ChangeEvent is one entry in the change stream
+type ChangeEvent struct {
+	ID    uint64 `json:"id"`
+	Event string `json:"event"`
+	Time  string `json:"time"`
+	Data  any    `json:"data,omitempty"`
+
+	tenant string // only subscribers of the same tenant see the event
+}
+
+// EventStreamReset is sent first when a client resumes from an event that
+// has already left the buffer, so it knows to reload its state
+const EventStreamReset = "stream.reset"
+
+const (
+	eventBufferSize     = 1000
+	subscriberQueueSize = 64
+)
+
+var heartbeatInterval = 15 * time.Second
+
+// EventHub keeps the most recent events in a ring buffer and fans new events
+// out to subscribers. Publishing never blocks: a subscriber whose queue is
+// full is dropped and can resume with Last-Event-ID.
+type EventHub struct {
+	mu          sync.Mutex
+	lastID      uint64
+	buffer      []ChangeEvent
+	start       int
+	subscribers map[*eventSubscriber]struct{}
+}
+
+type eventSubscriber struct {
+	tenant  string
+	events  chan ChangeEvent
+	dropped chan struct{}
+}
+
+func NewEventHub(size int) *EventHub {
+	return &EventHub{
+		buffer:      make([]ChangeEvent, 0, size),
+		subscribers: map[*eventSubscriber]struct{}{},
+	}
+}
+
+var events = NewEventHub(eventBufferSize)
+
+// publishEvent announces a change to the stream clients and webhook
+// subscribers of the request's tenant
+func publishEvent(ctx context.Context, event string, data any) {
+	tenantID := tenantFrom(ctx).ID
+	events.Publish(tenantID, event, data)
+	if err := webhooks.Publish(tenantID, event, data); err != nil {
+		fmt.Printf("Error queueing %s webhooks: %s\n", event, err)
+	}
+}
+
+// Publish records an event and hands it to every subscriber
+func (h *EventHub) Publish(tenantID, event string, data any) ChangeEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	ev := ChangeEvent{ID: h.lastID, Event: event, Time: time.Now().Format(time.RFC3339Nano), Data: data, tenant: tenantID}
+	if len(h.buffer) < cap(h.buffer) {
+		h.buffer = append(h.buffer, ev)
+	} else {
+		h.buffer[h.start] = ev
+		h.start = (h.start + 1) % len(h.buffer)
+	}
+
+	for sub := range h.subscribers {
+		if sub.tenant != tenantID {
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.dropped)
+		}
+	}
+	return ev
+}
+
+// Subscribe registers a subscriber for one tenant and returns that
+// tenant's buffered events after lastID. Both happen under one lock so no
+// event is missed or repeated. Only a resuming client gets a backlog; a new
+// one starts with the next event. Event IDs are shared by all tenants, so a
+// tenant sees gaps in the sequence.
+func (h *EventHub) Subscribe(tenantID string, lastID uint64, resume bool) (*eventSubscriber, []ChangeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !resume {
+		lastID = h.lastID
+	}
+	var backlog []ChangeEvent
+	if lastID > h.lastID {
+		// The client saw IDs from before a restart
+		backlog = append(backlog, ChangeEvent{ID: h.lastID, Event: EventStreamReset, Time: time.Now().Format(time.RFC3339Nano)})
+	} else if lastID < h.lastID {
+		oldest := h.lastID - uint64(len(h.buffer)) + 1
+		if lastID+1 < oldest {
+			backlog = append(backlog, ChangeEvent{ID: lastID, Event: EventStreamReset, Time: time.Now().Format(time.RFC3339Nano)})
+		}
+		for i := range h.buffer {
+			ev := h.buffer[(h.start+i)%len(h.buffer)]
+			if ev.ID > lastID && ev.tenant == tenantID {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+
+	sub := &eventSubscriber{
+		tenant:  tenantID,
+		events:  make(chan ChangeEvent, subscriberQueueSize),
+		dropped: make(chan struct{}),
+	}
+	h.subscribers[sub] = struct{}{}
+	return sub, backlog
+}
+
+func (h *EventHub) Unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers, sub)
+}
+
+// lastEventID reads the resume point from the Last-Event-ID header, or the
+// last_event_id query parameter for clients that cannot set headers; ok is
+// false when the client sent none
+func lastEventID(r *http.Request) (id uint64, ok bool) {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("last_event_id")
+	}
+	id, err := strconv.ParseUint(raw, 10, 64)
+	return id, err == nil
+}
+
+// Event stream handler function
+func handleEventStream(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if isWebSocketRequest(r) {
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			logEndpoint(r, startTime, http.StatusBadRequest)
+			return
+		}
+		lastID, resume := lastEventID(r)
+		streamWebSocket(conn, tenantFrom(r.Context()).ID, lastID, resume)
+		logEndpoint(r, startTime, http.StatusSwitchingProtocols)
+		return
+	}
+
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		logEndpoint(r, startTime, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	lastID, resume := lastEventID(r)
+	streamSSE(w, r, lastID, resume)
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+func streamSSE(w http.ResponseWriter, r *http.Request, lastID uint64, resume bool) {
+	sub, backlog := events.Subscribe(tenantFrom(r.Context()).ID, lastID, resume)
+	defer events.Unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	write := func(format string, args ...any) bool {
+		// A client that cannot take a write within the heartbeat interval is stalled
+		rc.SetWriteDeadline(time.Now().Add(heartbeatInterval))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	send := func(ev ChangeEvent) bool {
+		data, _ := json.Marshal(ev)
+		return write("id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Event, data)
+	}
+
+	if !write("retry: 3000\n\n") {
+		return
+	}
+	for _, ev := range backlog {
+		if !send(ev) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.dropped:
+			return
+		case ev := <-sub.events:
+			if !send(ev) {
+				return
+			}
+		case <-heartbeat.C:
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		}
+	}
+}
+
+func streamWebSocket(conn *wsConn, tenantID string, lastID uint64, resume bool) {
+	defer conn.Close()
+
+	sub, backlog := events.Subscribe(tenantID, lastID, resume)
+	defer events.Unsubscribe(sub)
+
+	closed := make(chan struct{})
+	go func() {
+		conn.readLoop()
+		close(closed)
+	}()
+
+	send := func(ev ChangeEvent) bool {
+		data, _ := json.Marshal(ev)
+		return conn.WriteMessage(wsOpText, data) == nil
+	}
+	for _, ev := range backlog {
+		if !send(ev) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-sub.dropped:
+			// 1008 policy violation: the client fell too far behind
+			conn.WriteMessage(wsOpClose, []byte{0x03, 0xF0})
+			return
+		case ev := <-sub.events:
+			if !send(ev) {
+				return
+			}
+		case <-heartbeat.C:
+			if conn.WriteMessage(wsOpPing, nil) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useEventHub gives a test a hub of its own that buffers size events
+func useEventHub(t *testing.T, size int) *EventHub {
+	t.Helper()
+	saved := events
+	events = NewEventHub(size)
+	t.Cleanup(func() { events = saved })
+	return events
+}
+
+// sseEvent is the id and event name of one event read off the stream
+type sseEvent struct {
+	id, event string
+}
+
+// openStream connects to the event stream and returns the events it sends.
+// It returns once the stream has started, when the client is subscribed.
+func openStream(t *testing.T, url string, header http.Header) <-chan sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "retry:") {
+		t.Fatalf("stream started with %q", lines.Text())
+	}
+
+	received := make(chan sseEvent, 16)
+	go func() {
+		var ev sseEvent
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				ev.event = strings.TrimPrefix(line, "event: ")
+			case line == "" && ev.event != "":
+				received <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return received
+}
+
+// nextEvents reads n events, failing the test if they do not come
+func nextEvents(t *testing.T, received <-chan sseEvent, n int) []sseEvent {
+	t.Helper()
+	var got []sseEvent
+	for len(got) < n {
+		got = append(got, waitFor(t, received, "an event"))
+	}
+	return got
+}
+
+func TestEventStreamResumesFromLastEventID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleEventStream))
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		query  string
+		want   []sseEvent
+	}{
+		{"header", http.Header{"Last-Event-Id": {"1"}}, "", []sseEvent{{"2", EventUserUpdated}, {"4", EventUserDeleted}}},
+		{"query parameter", nil, "?last_event_id=2", []sseEvent{{"4", EventUserDeleted}}},
+		{"header wins over the query", http.Header{"Last-Event-Id": {"2"}}, "?last_event_id=0", []sseEvent{{"4", EventUserDeleted}}},
+		{"up to date", http.Header{"Last-Event-Id": {"4"}}, "", nil},
+		{"ID from before a restart", http.Header{"Last-Event-Id": {"99"}}, "", []sseEvent{{"4", EventStreamReset}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := useEventHub(t, 4)
+			hub.Publish(defaultTenantID, EventUserCreated, nil) // 1
+			hub.Publish(defaultTenantID, EventUserUpdated, nil) // 2
+			hub.Publish("other", EventUserCreated, nil)         // 3
+			hub.Publish(defaultTenantID, EventUserDeleted, nil) // 4
+
+			received := openStream(t, server.URL+tt.query, tt.header)
+			got := nextEvents(t, received, len(tt.want))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("backlog %v, want %v", got, tt.want)
+			}
+			// nothing else is buffered: the next event is a new one
+			hub.Publish(defaultTenantID, EventFormSubmitted, nil)
+			if next := waitFor(t, received, "the live event"); next != (sseEvent{"5", EventFormSubmitted}) {
+				t.Errorf("after the backlog got %v, want the new event 5", next)
+			}
+		})
+	}
+}
+
+func TestEventStreamWithoutLastEventIDStartsLive(t *testing.T) {
+	hub := useEventHub(t, 4)
+	server := httptest.NewServer(http.HandlerFunc(handleEventStream))
+	t.Cleanup(server.Close)
+
+	hub.Publish(defaultTenantID, EventUserCreated, nil)
+	received := openStream(t, server.URL, nil)
+	hub.Publish("other", EventUserUpdated, nil)
+	hub.Publish(defaultTenantID, EventUserDeleted, nil)
+
+	if got := waitFor(t, received, "the live event"); got.event != EventUserDeleted {
+		t.Errorf("first event %v, want only the new %s of this tenant", got, EventUserDeleted)
+	}
+}
+
+func TestEventStreamResetsWhenTheBacklogIsGone(t *testing.T) {
+	hub := useEventHub(t, 2)
+	server := httptest.NewServer(http.HandlerFunc(handleEventStream))
+	t.Cleanup(server.Close)
+
+	for range 5 {
+		hub.Publish(defaultTenantID, EventUserUpdated, nil)
+	}
+	received := openStream(t, server.URL, http.Header{"Last-Event-Id": {"1"}})
+
+	want := []sseEvent{{"1", EventStreamReset}, {"4", EventUserUpdated}, {"5", EventUserUpdated}}
+	if got := nextEvents(t, received, 3); !slices.Equal(got, want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+
+	select {
+	case ev := <-received:
+		t.Errorf("unexpected event %v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
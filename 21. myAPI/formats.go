@@ -0,0 +1,1045 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Every non-JSON format goes through the same generic tree: values are
+// marshalled to JSON and decoded into ordered maps, slices, json.Number,
+// strings, bools and nil, so struct field order and json tags carry over.
+// Formats without types of their own (XML, YAML, CSV and forms) decode to
+// untyped text, and the field it is stored into decides what it is.
+
+// orderedMap is a JSON object that remembers its key order
+type orderedMap []keyValue
+
+type keyValue struct {
+	Key   string
+	Value any
+}
+
+func (m orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, kv := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(kv.Key)
+		value, err := json.Marshal(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// toTree converts any JSON-marshallable value into the generic tree
+func toTree(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return readTree(dec)
+}
+
+func readTree(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		m := orderedMap{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, keyValue{key.(string), value})
+		}
+		_, err = dec.Token()
+		return m, err
+	case json.Delim('['):
+		list := []any{}
+		for dec.More() {
+			value, err := readTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = dec.Token()
+		return list, err
+	}
+	return tok, nil
+}
+
+// untyped is a text scalar from a format without types, such as the 2024
+// in name=2024, which is a string or a number depending on where it goes
+type untyped string
+
+// fromTree stores a generic tree into v using its json tags
+func fromTree(tree any, v any) error {
+	data, err := json.Marshal(settle(tree, reflect.TypeOf(v)))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// settle gives the untyped scalars of a tree the types of the fields of t
+// they will be stored into: a string field keeps "007" as it is, an int
+// field gets the number 7. Where t says nothing, in an any or a map of
+// them, the type is guessed.
+func settle(node any, t reflect.Type) any {
+	nullable := false
+	for t != nil && t.Kind() == reflect.Pointer {
+		t, nullable = t.Elem(), true
+	}
+	if t == nil || t.Kind() == reflect.Interface {
+		return guessTypes(node)
+	}
+
+	switch node := node.(type) {
+	case untyped:
+		return settleScalar(string(node), t, nullable)
+	case orderedMap:
+		settled := make(orderedMap, len(node))
+		for i, kv := range node {
+			var field reflect.Type
+			switch t.Kind() {
+			case reflect.Struct:
+				field = jsonField(t, kv.Key)
+			case reflect.Map:
+				field = t.Elem()
+			}
+			settled[i] = keyValue{kv.Key, settle(kv.Value, field)}
+		}
+		return settled
+	case []any:
+		var elem reflect.Type
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			elem = t.Elem()
+		}
+		settled := make([]any, len(node))
+		for i, item := range node {
+			settled[i] = settle(item, elem)
+		}
+		return settled
+	}
+	return node
+}
+
+// settleScalar types one text scalar for a value of type t. Text that does
+// not fit t is passed on as a string, for json.Unmarshal to report.
+func settleScalar(s string, t reflect.Type, nullable bool) any {
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return s
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return s
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(strconv.FormatInt(n, 10))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return json.Number(strconv.FormatUint(n, 10))
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	case reflect.Slice, reflect.Array:
+		// a list given once, as in a form with a single tags=go
+		if t.Elem().Kind() != reflect.Uint8 {
+			return []any{settleScalar(s, t.Elem(), false)}
+		}
+	}
+	if nullable && s == "" {
+		return nil
+	}
+	return s
+}
+
+// jsonField finds the type of the field of struct t that encoding/json
+// stores key into, or nil if there is none
+func jsonField(t reflect.Type, key string) reflect.Type {
+	var folded reflect.Type
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if found := jsonField(embedded, key); found != nil {
+					return found
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if name == key {
+			return field.Type
+		}
+		if folded == nil && strings.EqualFold(name, key) {
+			folded = field.Type
+		}
+	}
+	return folded
+}
+
+// guessTypes types the untyped scalars of a tree by their looks
+func guessTypes(node any) any {
+	switch node := node.(type) {
+	case untyped:
+		return typedScalar(string(node))
+	case orderedMap:
+		guessed := make(orderedMap, len(node))
+		for i, kv := range node {
+			guessed[i] = keyValue{kv.Key, guessTypes(kv.Value)}
+		}
+		return guessed
+	case []any:
+		guessed := make([]any, len(node))
+		for i, item := range node {
+			guessed[i] = guessTypes(item)
+		}
+		return guessed
+	}
+	return node
+}
+
+// typedScalar guesses the type of an untyped text scalar for a destination
+// that does not say
+func typedScalar(s string) any {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null", "~":
+		return nil
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil && !strings.ContainsAny(s, "xXnN_") {
+		return json.Number(s)
+	}
+	return s
+}
+
+// scalarText renders a scalar leaf as plain text
+func scalarText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// ---- XML ----
+
+var xmlNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+func encodeXML(w io.Writer, v any) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	if err := encodeXMLElement(enc, "response", tree); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// encodeXMLElement writes v as an element called name. Keys that are not
+// valid element names become <entry key="..."> instead.
+func encodeXMLElement(enc *xml.Encoder, name string, v any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !xmlNamePattern.MatchString(name) || strings.HasPrefix(strings.ToLower(name), "xml") {
+		start = xml.StartElement{
+			Name: xml.Name{Local: "entry"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+		}
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case nil:
+	case orderedMap:
+		for _, kv := range v {
+			if err := encodeXMLElement(enc, kv.Key, kv.Value); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if err := encodeXMLElement(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := enc.EncodeToken(xml.CharData(scalarText(v))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// decodeXML reads the children of the root element. Repeated child names,
+// or children all named "item", become lists.
+func decodeXML(r io.Reader, v any) error {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			tree, err := readXMLElement(dec, start)
+			if err != nil {
+				return err
+			}
+			return fromTree(tree, v)
+		}
+	}
+}
+
+func readXMLElement(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	var text strings.Builder
+	children := orderedMap{}
+	counts := map[string]int{}
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			child, err := readXMLElement(dec, tok)
+			if err != nil {
+				return nil, err
+			}
+			name := tok.Name.Local
+			for _, a := range tok.Attr {
+				if name == "entry" && a.Name.Local == "key" {
+					name = a.Value
+				}
+			}
+			children = append(children, keyValue{name, child})
+			counts[name]++
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			if len(children) == 0 {
+				if strings.TrimSpace(text.String()) == "" {
+					return nil, nil
+				}
+				return untyped(text.String()), nil
+			}
+			if counts["item"] == len(children) {
+				list := make([]any, len(children))
+				for i, kv := range children {
+					list[i] = kv.Value
+				}
+				return list, nil
+			}
+			return groupRepeated(children, counts), nil
+		}
+	}
+}
+
+func groupRepeated(children orderedMap, counts map[string]int) orderedMap {
+	grouped := orderedMap{}
+	index := map[string]int{}
+	for _, kv := range children {
+		if counts[kv.Key] == 1 {
+			grouped = append(grouped, kv)
+			continue
+		}
+		if i, ok := index[kv.Key]; ok {
+			grouped[i].Value = append(grouped[i].Value.([]any), kv.Value)
+			continue
+		}
+		index[kv.Key] = len(grouped)
+		grouped = append(grouped, keyValue{kv.Key, []any{kv.Value}})
+	}
+	return grouped
+}
+
+// ---- YAML ----
+
+var yamlPlainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+func encodeYAML(w io.Writer, v any) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if isYAMLBlock(tree) {
+		writeYAML(&b, tree, 0)
+	} else {
+		b.WriteString(yamlScalar(tree) + "\n")
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// isYAMLBlock reports whether v is written as an indented block rather than inline
+func isYAMLBlock(v any) bool {
+	switch v := v.(type) {
+	case orderedMap:
+		return len(v) > 0
+	case []any:
+		return len(v) > 0
+	}
+	return false
+}
+
+func yamlScalar(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case orderedMap:
+		return "{}"
+	case []any:
+		return "[]"
+	case string:
+		// A JSON string is a valid double-quoted YAML scalar
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return scalarText(v)
+}
+
+func yamlKey(key string) string {
+	if yamlPlainKey.MatchString(key) {
+		return key
+	}
+	return yamlScalar(key)
+}
+
+func writeYAML(b *strings.Builder, v any, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch v := v.(type) {
+	case orderedMap:
+		for _, kv := range v {
+			b.WriteString(pad + yamlKey(kv.Key) + ":")
+			if isYAMLBlock(kv.Value) {
+				b.WriteString("\n")
+				writeYAML(b, kv.Value, indent+2)
+			} else {
+				b.WriteString(" " + yamlScalar(kv.Value) + "\n")
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if !isYAMLBlock(item) {
+				b.WriteString(pad + "- " + yamlScalar(item) + "\n")
+				continue
+			}
+			// Render the item one level deeper, then pull its first line up after the dash
+			var sub strings.Builder
+			writeYAML(&sub, item, indent+2)
+			b.WriteString(pad + "- " + sub.String()[indent+2:])
+		}
+	}
+}
+
+type yamlLine struct {
+	indent int
+	text   string
+	number int
+}
+
+// decodeYAML understands the block subset of YAML this API writes: nested
+// mappings and sequences of scalars, plus JSON-style flow documents
+func decodeYAML(r io.Reader, v any) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return json.Unmarshal([]byte(trimmed), v)
+	}
+
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" || text == "..." {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return fmt.Errorf("yaml line %d: tabs are not allowed for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{indent: len(raw) - len(text), text: text, number: i + 1})
+	}
+	if len(lines) == 0 {
+		return errors.New("yaml document is empty")
+	}
+
+	tree, next, err := parseYAMLBlock(lines, 0, lines[0].indent)
+	if err != nil {
+		return err
+	}
+	if next < len(lines) {
+		return fmt.Errorf("yaml line %d: unexpected indentation", lines[next].number)
+	}
+	return fromTree(tree, v)
+}
+
+func parseYAMLBlock(lines []yamlLine, i, indent int) (any, int, error) {
+	if lines[i].text == "-" || strings.HasPrefix(lines[i].text, "- ") {
+		return parseYAMLSequence(lines, i, indent)
+	}
+	return parseYAMLMapping(lines, i, indent)
+}
+
+func parseYAMLSequence(lines []yamlLine, i, indent int) (any, int, error) {
+	list := []any{}
+	for i < len(lines) && lines[i].indent == indent && (lines[i].text == "-" || strings.HasPrefix(lines[i].text, "- ")) {
+		content := strings.TrimSpace(strings.TrimPrefix(lines[i].text, "-"))
+		switch {
+		case content == "":
+			if i+1 >= len(lines) || lines[i+1].indent <= indent {
+				list = append(list, nil)
+				i++
+				continue
+			}
+			item, next, err := parseYAMLBlock(lines, i+1, lines[i+1].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			list, i = append(list, item), next
+		case isYAMLMappingLine(content) || strings.HasPrefix(content, "- "):
+			// "- key: value" starts a nested block two columns in
+			lines[i] = yamlLine{indent: indent + 2, text: content, number: lines[i].number}
+			item, next, err := parseYAMLBlock(lines, i, indent+2)
+			if err != nil {
+				return nil, 0, err
+			}
+			list, i = append(list, item), next
+		default:
+			value, err := parseYAMLScalar(content)
+			if err != nil {
+				return nil, 0, fmt.Errorf("yaml line %d: %w", lines[i].number, err)
+			}
+			list = append(list, value)
+			i++
+		}
+	}
+	return list, i, nil
+}
+
+func parseYAMLMapping(lines []yamlLine, i, indent int) (any, int, error) {
+	m := orderedMap{}
+	for i < len(lines) && lines[i].indent == indent {
+		key, rest, ok := splitYAMLMapping(lines[i].text)
+		if !ok {
+			return nil, 0, fmt.Errorf("yaml line %d: expected key: value", lines[i].number)
+		}
+		i++
+
+		if rest != "" {
+			value, err := parseYAMLScalar(rest)
+			if err != nil {
+				return nil, 0, fmt.Errorf("yaml line %d: %w", lines[i-1].number, err)
+			}
+			m = append(m, keyValue{key, value})
+			continue
+		}
+
+		// A nested block is indented further, except sequences which may sit at the key's indent
+		if i < len(lines) && (lines[i].indent > indent ||
+			(lines[i].indent == indent && (lines[i].text == "-" || strings.HasPrefix(lines[i].text, "- ")))) {
+			value, next, err := parseYAMLBlock(lines, i, lines[i].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			m, i = append(m, keyValue{key, value}), next
+			continue
+		}
+		m = append(m, keyValue{key, nil})
+	}
+	return m, i, nil
+}
+
+func isYAMLMappingLine(text string) bool {
+	_, _, ok := splitYAMLMapping(text)
+	return ok
+}
+
+// splitYAMLMapping splits "key: value" or "key:", honouring a quoted key
+func splitYAMLMapping(text string) (string, string, bool) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, `'`) {
+		end := strings.Index(text[1:], text[:1])
+		if end < 0 {
+			return "", "", false
+		}
+		key, err := parseYAMLScalar(text[:end+2])
+		if err != nil {
+			return "", "", false
+		}
+		rest := text[end+2:]
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+		return scalarText(key), strings.TrimSpace(rest[1:]), true
+	}
+
+	if strings.HasSuffix(text, ":") {
+		return text[:len(text)-1], "", true
+	}
+	key, rest, ok := strings.Cut(text, ": ")
+	if !ok {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(rest), true
+}
+
+func parseYAMLScalar(s string) (any, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, `'`):
+		if len(s) < 2 || !strings.HasSuffix(s, `'`) {
+			return nil, errors.New("unterminated single-quoted string")
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{"):
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		return readTree(dec)
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	if s == "null" || s == "~" {
+		return nil, nil
+	}
+	return untyped(s), nil
+}
+
+// ---- CSV ----
+
+// encodeCSV writes the response data as a table: a list of objects becomes
+// one row each, a single object one row, and anything else the envelope
+func encodeCSV(w io.Writer, v any) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+
+	var rows []orderedMap
+	if envelope, ok := tree.(orderedMap); ok {
+		tree = envelope
+		for _, kv := range envelope {
+			if kv.Key == "data" {
+				tree = kv.Value
+			}
+		}
+	}
+	switch data := tree.(type) {
+	case []any:
+		for _, item := range data {
+			row, ok := item.(orderedMap)
+			if !ok {
+				row = orderedMap{{"value", item}}
+			}
+			rows = append(rows, row)
+		}
+	case orderedMap:
+		rows = []orderedMap{data}
+	}
+
+	var header []string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		for _, kv := range row {
+			if !seen[kv.Key] {
+				seen[kv.Key] = true
+				header = append(header, kv.Key)
+			}
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	if len(header) > 0 {
+		cw.Write(header)
+	}
+	for _, row := range rows {
+		values := map[string]any{}
+		for _, kv := range row {
+			values[kv.Key] = kv.Value
+		}
+		record := make([]string, len(header))
+		for i, key := range header {
+			record[i] = scalarText(values[key])
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// decodeCSV reads a header row and one data row into v
+func decodeCSV(r io.Reader, v any) error {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) != 2 {
+		return errors.New("csv body must have a header row and exactly one data row")
+	}
+	m := orderedMap{}
+	for i, key := range records[0] {
+		if i < len(records[1]) {
+			m = append(m, keyValue{strings.TrimSpace(key), untyped(records[1][i])})
+		}
+	}
+	return fromTree(m, v)
+}
+
+// ---- Forms ----
+
+func decodeForm(r io.Reader, v any) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+	m := orderedMap{}
+	for key, list := range values {
+		if len(list) == 1 {
+			m = append(m, keyValue{key, untyped(list[0])})
+			continue
+		}
+		items := make([]any, len(list))
+		for i, item := range list {
+			items[i] = untyped(item)
+		}
+		m = append(m, keyValue{key, items})
+	}
+	return fromTree(m, v)
+}
+
+// ---- MessagePack ----
+
+func encodeMsgpack(w io.Writer, v any) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	writeMsgpack(&buf, tree)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func writeMsgpack(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			writeMsgpackInt(buf, n)
+			return
+		}
+		f, _ := v.Float64()
+		buf.WriteByte(0xcb)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	case string:
+		writeMsgpackLength(buf, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []any:
+		writeMsgpackLength(buf, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			writeMsgpack(buf, item)
+		}
+	case orderedMap:
+		writeMsgpackLength(buf, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, kv := range v {
+			writeMsgpack(buf, kv.Key)
+			writeMsgpack(buf, kv.Value)
+		}
+	}
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0 && n <= 127:
+		buf.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		buf.WriteByte(byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		buf.Write([]byte{0xd0, byte(n)})
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		buf.WriteByte(0xd3)
+		buf.Write(binary.BigEndian.AppendUint64(nil, uint64(n)))
+	}
+}
+
+// writeMsgpackLength writes a fix, 8, 16 or 32 bit length prefix; a zero code means the width is not available
+func writeMsgpackLength(buf *bytes.Buffer, n int, fix byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint8 && code8 != 0:
+		buf.Write([]byte{code8, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(code32)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+func decodeMsgpack(r io.Reader, v any) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	d := &msgpackReader{data: data}
+	tree, err := d.read(0)
+	if err != nil {
+		return err
+	}
+	if d.pos != len(d.data) {
+		return errors.New("msgpack: trailing data after value")
+	}
+	return fromTree(tree, v)
+}
+
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+func (d *msgpackReader) take(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, errMsgpackShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackReader) uint(size int) (uint64, error) {
+	b, err := d.take(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (d *msgpackReader) read(depth int) (any, error) {
+	if depth > 64 {
+		return nil, errors.New("msgpack: nesting too deep")
+	}
+	head, err := d.take(1)
+	if err != nil {
+		return nil, err
+	}
+	c := head[0]
+
+	switch {
+	case c <= 0x7f:
+		return json.Number(strconv.Itoa(int(c))), nil
+	case c >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(c)))), nil
+	case c >= 0xa0 && c <= 0xbf:
+		return d.str(int(c & 0x1f))
+	case c >= 0x90 && c <= 0x9f:
+		return d.array(int(c&0x0f), depth)
+	case c >= 0x80 && c <= 0x8f:
+		return d.mapping(int(c&0x0f), depth)
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6, 0xd9, 0xda, 0xdb:
+		size := map[byte]int{0xc4: 1, 0xc5: 2, 0xc6: 4, 0xd9: 1, 0xda: 2, 0xdb: 4}[c]
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case 0xca:
+		n, err := d.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatFloat(float64(math.Float32frombits(uint32(n))), 'g', -1, 32)), nil
+	case 0xcb:
+		n, err := d.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatFloat(math.Float64frombits(n), 'g', -1, 64)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend from the encoded width
+		shift := 64 - 8*size
+		return json.Number(strconv.FormatInt(int64(n<<shift)>>shift, 10)), nil
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapping(int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+}
+
+func (d *msgpackReader) str(n int) (any, error) {
+	b, err := d.take(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackReader) array(n, depth int) (any, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackShort
+	}
+	list := make([]any, 0, n)
+	for range n {
+		item, err := d.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func (d *msgpackReader) mapping(n, depth int) (any, error) {
+	if 2*n > len(d.data)-d.pos {
+		return nil, errMsgpackShort
+	}
+	m := make(orderedMap, 0, n)
+	for range n {
+		key, err := d.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, keyValue{scalarText(key), value})
+	}
+	return m, nil
+}
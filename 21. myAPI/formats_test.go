@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type codecSample struct {
+	Name   string            `json:"name"`
+	Code   string            `json:"code"`
+	Count  int               `json:"count"`
+	Big    int64             `json:"big"`
+	Ratio  float64           `json:"ratio"`
+	Active bool              `json:"active"`
+	Tags   []string          `json:"tags"`
+	Scores []int             `json:"scores"`
+	Owner  *User             `json:"owner"`
+	Labels map[string]string `json:"labels"`
+}
+
+var codecSamples = []struct {
+	name  string
+	value codecSample
+}{
+	{"zero", codecSample{}},
+	{"plain", codecSample{
+		Name: "Ada", Code: "x1", Count: 3, Big: 1 << 40, Ratio: 0.25, Active: true,
+		Tags: []string{"go", "api"}, Scores: []int{1, 2, 3},
+		Owner:  &User{ID: 7, Name: "Grace", Email: "grace@example.com"},
+		Labels: map[string]string{"team": "core"},
+	}},
+	{"strings that look typed", codecSample{
+		Name: "2024", Code: "007", Tags: []string{"true", "null", "1e3", "-0", "~"},
+		Labels: map[string]string{"version": "1.10", "flag": "false"},
+	}},
+	{"one item lists", codecSample{Tags: []string{"only"}, Scores: []int{42}}},
+	{"number edges", codecSample{
+		Count: -33, Big: math.MaxInt64, Ratio: -1.5e-7, Scores: []int{0, 127, 128, -32, -33, 255, 256, 65536, math.MinInt32},
+	}},
+	{"markup and punctuation", codecSample{
+		Name: `<b>"Tom" & 'Jerry'</b>`, Code: "a: b # c", Tags: []string{"- dash", "[x]", "{y}", "é ü 漢字"},
+		Labels: map[string]string{"needs space": "v", "xmlish": "w", "1st": "z"},
+	}},
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	codecs := []struct {
+		name   string
+		encode func(io.Writer, any) error
+		decode func(io.Reader, any) error
+	}{
+		{"xml", encodeXML, decodeXML},
+		{"yaml", encodeYAML, decodeYAML},
+		{"msgpack", encodeMsgpack, decodeMsgpack},
+	}
+	for _, codec := range codecs {
+		for _, sample := range codecSamples {
+			t.Run(codec.name+"/"+sample.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := codec.encode(&buf, sample.value); err != nil {
+					t.Fatalf("encode: %v", err)
+				}
+				var got codecSample
+				if err := codec.decode(bytes.NewReader(buf.Bytes()), &got); err != nil {
+					t.Fatalf("decode: %v\n%s", err, buf.Bytes())
+				}
+				if !reflect.DeepEqual(got, sample.value) {
+					t.Errorf("round trip changed the value\n got: %+v\nwant: %+v\nencoded:\n%s", got, sample.value, buf.Bytes())
+				}
+			})
+		}
+	}
+}
+
+func TestUntypedScalarsFollowTheField(t *testing.T) {
+	tests := []struct {
+		name    string
+		decode  func(io.Reader, any) error
+		body    string
+		want    codecSample
+		wantErr bool
+	}{
+		{"form string field keeps digits", decodeForm, "name=2024&count=2024", codecSample{Name: "2024", Count: 2024}, false},
+		{"form single value into list", decodeForm, "tags=go&scores=5", codecSample{Tags: []string{"go"}, Scores: []int{5}}, false},
+		{"form repeated values", decodeForm, "tags=1&tags=2&scores=1&scores=2", codecSample{Tags: []string{"1", "2"}, Scores: []int{1, 2}}, false},
+		{"form bool", decodeForm, "active=true&name=true", codecSample{Active: true, Name: "true"}, false},
+		{"form number that is not one", decodeForm, "count=abc", codecSample{}, true},
+		{"xml leading zeros", decodeXML, "<r><code>007</code><count>007</count></r>", codecSample{Code: "007", Count: 7}, false},
+		{"xml nested struct", decodeXML, "<r><owner><id>9</id><name>42</name></owner></r>", codecSample{Owner: &User{ID: 9, Name: "42"}}, false},
+		{"xml map values stay strings", decodeXML, "<r><labels><v>1.0</v></labels></r>", codecSample{Labels: map[string]string{"v": "1.0"}}, false},
+		{"yaml plain scalars", decodeYAML, "name: 1e3\nratio: 1e3\nactive: false\n", codecSample{Name: "1e3", Ratio: 1000}, false},
+		{"yaml null", decodeYAML, "owner: null\ntags: ~\nname: null\n", codecSample{}, false},
+		{"csv row", decodeCSV, "name,count\n0042,0042\n", codecSample{Name: "0042", Count: 42}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got codecSample
+			err := tt.decode(strings.NewReader(tt.body), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decoded %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUntypedScalarsIntoAny(t *testing.T) {
+	var got map[string]any
+	if err := decodeForm(strings.NewReader("n=12&s=abc&b=true"), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"n": float64(12), "s": "abc", "b": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestEncodeXML(t *testing.T) {
+	var out bytes.Buffer
+	value := map[string]any{"name": `<b>&"x"`, "1st": nil, "list": []int{1, 2}}
+	if err := encodeXML(&out, value); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<response><entry key="1st"></entry><list><item>1</item><item>2</item></list><name>&lt;b&gt;&amp;&#34;x&#34;</name></response>` + "\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
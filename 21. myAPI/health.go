@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Health settings
+var (
+	healthCheckTimeout = 2 * time.Second
+	minUploadsFreeMB   = int64(100) // readiness fails below this much free space for uploads
+)
+
+// registerHealthFlags adds the readiness flags to fs
+func registerHealthFlags(fs *flag.FlagSet) {
+	fs.Int64Var(&minUploadsFreeMB, "uploads-min-free-mb", minUploadsFreeMB, "free space in MB the uploads directory needs for /readyz to pass")
+}
+
+var errDiskFreeUnsupported = errors.New("free space cannot be measured on this platform")
+
+// Checker is one dependency check. Subsystems register checkers with the
+// liveness or readiness registry; a nil error means healthy.
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+// CheckFunc adapts a function to the Checker interface
+type CheckFunc struct {
+	CheckName string
+	Fn        func(ctx context.Context) error
+}
+
+func (c CheckFunc) Name() string                    { return c.CheckName }
+func (c CheckFunc) Check(ctx context.Context) error { return c.Fn(ctx) }
+
+// HealthRegistry runs a set of checks together
+type HealthRegistry struct {
+	mu       sync.RWMutex
+	checkers []Checker
+}
+
+var (
+	liveness  = &HealthRegistry{}
+	readiness = &HealthRegistry{}
+)
+
+func (h *HealthRegistry) Register(checkers ...Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkers = append(h.checkers, checkers...)
+}
+
+// HealthReport is the JSON body of /healthz and /readyz
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Run runs every check concurrently, each with its own timeout
+func (h *HealthRegistry) Run(ctx context.Context) HealthReport {
+	h.mu.RLock()
+	checkers := append([]Checker(nil), h.checkers...)
+	h.mu.RUnlock()
+
+	report := HealthReport{Status: "ok", Checks: make([]CheckResult, len(checkers))}
+	var wg sync.WaitGroup
+	for i, checker := range checkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = runCheck(ctx, checker)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != "ok" {
+			report.Status = "unavailable"
+		}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, checker Checker) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() { errs <- checker.Check(ctx) }()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", healthCheckTimeout)
+	}
+
+	result := CheckResult{
+		Name:      checker.Name(),
+		Status:    "ok",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = "failing"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkUploadsSpace fails when the uploads filesystem is short of space
+func checkUploadsSpace(ctx context.Context) error {
+	dir := uploadsDir
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		dir = "." // created on the first upload
+	}
+	free, err := diskFree(dir)
+	if errors.Is(err, errDiskFreeUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if minimum := uint64(minUploadsFreeMB) << 20; free < minimum {
+		return fmt.Errorf("%d MB free, need at least %d MB", free>>20, minUploadsFreeMB)
+	}
+	return nil
+}
+
+func registerHealthChecks() {
+	readiness.Register(
+		CheckFunc{"user_stores", tenants.CheckStores},
+		CheckFunc{"uploads_disk_space", checkUploadsSpace},
+		CheckFunc{"webhook_worker", webhooks.Check},
+	)
+}
+
+// Health handler function
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	sendHealthReport(w, r, liveness)
+}
+
+// Readiness handler function
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	sendHealthReport(w, r, readiness)
+}
+
+func sendHealthReport(w http.ResponseWriter, r *http.Request, registry *HealthRegistry) {
+	startTime := time.Now()
+
+	report := registry.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+	logEndpoint(r, startTime, status)
+}
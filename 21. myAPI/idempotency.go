@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Idempotency settings
+var (
+	idempotencyTTL        = 24 * time.Hour // how long a stored response is replayed
+	maxIdempotentBody     = 32 << 20       // requests with a key are buffered to fingerprint them
+	maxIdempotencyKeySize = 255
+
+	// Stored responses are dropped, soonest to expire first, beyond these
+	maxIdempotencyEntries = 10000
+	maxIdempotencyBytes   = 256 << 20
+
+	idempotencySweepInterval = time.Minute
+)
+
+// registerIdempotencyFlags adds the Idempotency-Key flags to fs
+func registerIdempotencyFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&idempotencyTTL, "idempotency-ttl", idempotencyTTL, "how long responses to requests with an Idempotency-Key are replayed")
+}
+
+// idempotencyEntry is the first response sent for an Idempotency-Key
+type idempotencyEntry struct {
+	fingerprint string
+	expires     time.Time
+	done        bool // false while the first request is still running
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// IdempotencyStore remembers responses by Idempotency-Key
+type IdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	bytes   int // size of the stored bodies
+}
+
+var idempotencyKeys = &IdempotencyStore{entries: map[string]*idempotencyEntry{}}
+
+// Run drops expired responses every idempotencySweepInterval until stop is closed
+func (s *IdempotencyStore) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(idempotencySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.sweep(now)
+		}
+	}
+}
+
+// sweep drops the responses that expired by now
+func (s *IdempotencyStore) sweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, entry := range s.entries {
+		if entry.done && now.After(entry.expires) {
+			s.drop(key)
+		}
+	}
+}
+
+// begin claims key for a request. It returns the stored entry when the key
+// has been seen before, or nil when the caller should run the request.
+// An expired entry that was not swept yet counts as unseen.
+func (s *IdempotencyStore) begin(key, fingerprint string) *idempotencyEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && !(entry.done && time.Now().After(entry.expires)) {
+		copied := *entry
+		return &copied
+	}
+	s.drop(key)
+	s.entries[key] = &idempotencyEntry{fingerprint: fingerprint}
+	return nil
+}
+
+// finish stores the response for key, or releases the key so a retry can
+// run again when the response should not be replayed
+func (s *IdempotencyStore) finish(key string, rec *responseRecorder) {
+	// Server errors and cancelled requests are usually transient, so
+	// retries should get a fresh attempt
+	if rec.snapshot == nil || rec.status >= 500 || rec.status == statusClientClosedRequest {
+		s.release(key)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	entry.done = true
+	entry.expires = time.Now().Add(idempotencyTTL)
+	entry.status = rec.status
+	entry.header = rec.snapshot
+	entry.body = rec.body.Bytes()
+	s.bytes += len(entry.body)
+	s.evict()
+}
+
+// release forgets key, for a request that did not complete
+func (s *IdempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drop(key)
+}
+
+// drop removes key; callers must hold s.mu
+func (s *IdempotencyStore) drop(key string) {
+	if entry, ok := s.entries[key]; ok {
+		s.bytes -= len(entry.body)
+		delete(s.entries, key)
+	}
+}
+
+// evict drops stored responses, those expiring first before the others,
+// until the store is within its limits. Requests still running are kept.
+// Callers must hold s.mu.
+func (s *IdempotencyStore) evict() {
+	if len(s.entries) <= maxIdempotencyEntries && s.bytes <= maxIdempotencyBytes {
+		return
+	}
+	var done []string
+	for key, entry := range s.entries {
+		if entry.done {
+			done = append(done, key)
+		}
+	}
+	slices.SortFunc(done, func(a, b string) int { return s.entries[a].expires.Compare(s.entries[b].expires) })
+	for _, key := range done {
+		if len(s.entries) <= maxIdempotencyEntries && s.bytes <= maxIdempotencyBytes {
+			return
+		}
+		s.drop(key)
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	snapshot http.Header
+	body     bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.snapshot == nil {
+		rec.status = status
+		rec.snapshot = rec.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.snapshot == nil {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// idempotent makes retries of a POST carrying an Idempotency-Key safe: the
+// first response is stored and replayed verbatim, and reusing the key for
+// a different request is refused
+func idempotent(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			handler(w, r)
+			return
+		}
+		startTime := time.Now()
+		if len(key) > maxIdempotencyKeySize {
+			http.Error(w, "Idempotency-Key must be at most "+strconv.Itoa(maxIdempotencyKeySize)+" characters", http.StatusBadRequest)
+			logEndpoint(r, startTime, http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxIdempotentBody)))
+		if err != nil {
+			if requestAborted(w, r, startTime, err) {
+				return
+			}
+			http.Error(w, err.Error(), decodeStatus(err))
+			logEndpoint(r, startTime, decodeStatus(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := requestFingerprint(r, body)
+		// Keys are per tenant, so tenants cannot see each other's responses
+		key = tenantFrom(r.Context()).ID + "/" + key
+		entry := idempotencyKeys.begin(key, fingerprint)
+		switch {
+		case entry == nil:
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			completed := false
+			defer func() {
+				// A handler that panicked leaves nothing to replay
+				if !completed {
+					idempotencyKeys.release(key)
+				}
+			}()
+			handler(rec, r)
+			completed = true
+			idempotencyKeys.finish(key, rec)
+		case entry.fingerprint != fingerprint:
+			http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+			logEndpoint(r, startTime, http.StatusUnprocessableEntity)
+		case !entry.done:
+			http.Error(w, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+			logEndpoint(r, startTime, http.StatusConflict)
+		default:
+			for name, values := range entry.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			logEndpoint(r, startTime, entry.status)
+		}
+	}
+}
+
+// requestFingerprint identifies a request by method, path and body. The
+// multipart boundary is left out, since clients pick a new one per attempt.
+func requestFingerprint(r *http.Request, body []byte) string {
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && params["boundary"] != "" {
+		body = bytes.ReplaceAll(body, []byte(params["boundary"]), nil)
+	}
+	sum := sha256.Sum256(body)
+	return r.Method + " " + r.URL.Path + " " + hex.EncodeToString(sum[:])
+}
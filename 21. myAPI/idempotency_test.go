@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useIdempotencyStore gives a test an empty store of its own
+func useIdempotencyStore(t *testing.T) *IdempotencyStore {
+	t.Helper()
+	saved := idempotencyKeys
+	idempotencyKeys = &IdempotencyStore{entries: map[string]*idempotencyEntry{}}
+	t.Cleanup(func() { idempotencyKeys = saved })
+	return idempotencyKeys
+}
+
+func postWithKey(handler http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/post", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	useIdempotencyStore(t)
+	var calls atomic.Int32
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Location", fmt.Sprintf("/api/users/%d", n))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"id":%d}`, n)
+	})
+
+	first := postWithKey(handler, "k1", `{"name":"Ada"}`)
+	second := postWithKey(handler, "k1", `{"name":"Ada"}`)
+	if calls.Load() != 1 {
+		t.Fatalf("handler ran %d times, want once", calls.Load())
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("replay %d %q, want %d %q", second.Code, second.Body, first.Code, first.Body)
+	}
+	if second.Header().Get("Location") != "/api/users/1" || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replayed headers %v", second.Header())
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("the first response is marked as replayed")
+	}
+
+	postWithKey(handler, "k2", `{"name":"Ada"}`)
+	if calls.Load() != 2 {
+		t.Errorf("a new key did not run the handler")
+	}
+}
+
+func TestIdempotencyKeyReusedForDifferentRequest(t *testing.T) {
+	useIdempotencyStore(t)
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	postWithKey(handler, "k1", `{"name":"Ada"}`)
+	if w := postWithKey(handler, "k1", `{"name":"Grace"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key got %d, want 422", w.Code)
+	}
+}
+
+func TestIdempotentConcurrentFirstRequests(t *testing.T) {
+	useIdempotencyStore(t)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	const clients = 10
+	codes := make(chan int, clients)
+	var wg sync.WaitGroup
+	for range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- postWithKey(handler, "k1", `{"name":"Ada"}`).Code
+		}()
+	}
+
+	// every request but the one running gets 409 straight away
+	conflicts := 0
+	for conflicts < clients-1 {
+		select {
+		case code := <-codes:
+			if code != http.StatusConflict {
+				t.Fatalf("got %d while the first request runs, want 409", code)
+			}
+			conflicts++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d requests answered", conflicts, clients-1)
+		}
+	}
+	close(release)
+	wg.Wait()
+	if code := <-codes; code != http.StatusCreated {
+		t.Errorf("the first request got %d", code)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want once", calls.Load())
+	}
+}
+
+func TestIdempotencyKeyReleasedWhenHandlerPanics(t *testing.T) {
+	useIdempotencyStore(t)
+	var calls atomic.Int32
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic did not reach the caller")
+			}
+		}()
+		postWithKey(handler, "k1", `{}`)
+	}()
+
+	if w := postWithKey(handler, "k1", `{}`); w.Code != http.StatusCreated {
+		t.Errorf("retry after a panic got %d, want 201", w.Code)
+	}
+}
+
+func TestIdempotencyStoreLimits(t *testing.T) {
+	s := useIdempotencyStore(t)
+	savedEntries, savedBytes := maxIdempotencyEntries, maxIdempotencyBytes
+	maxIdempotencyEntries, maxIdempotencyBytes = 3, 10
+	t.Cleanup(func() { maxIdempotencyEntries, maxIdempotencyBytes = savedEntries, savedBytes })
+
+	store := func(key, body string) {
+		s.begin(key, "fp")
+		rec := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+		rec.WriteHeader(http.StatusCreated)
+		rec.Write([]byte(body))
+		s.finish(key, rec)
+	}
+	for i := range 5 {
+		store(fmt.Sprint("k", i), "x")
+	}
+	if len(s.entries) != 3 || s.entries["k4"] == nil || s.entries["k0"] != nil {
+		t.Errorf("kept %d entries, want the 3 newest", len(s.entries))
+	}
+
+	store("big", "0123456789")
+	if s.bytes > maxIdempotencyBytes {
+		t.Errorf("store holds %d bytes, limit %d", s.bytes, maxIdempotencyBytes)
+	}
+
+	s.begin("running", "fp")
+	s.sweep(time.Now().Add(2 * idempotencyTTL))
+	if len(s.entries) != 1 || s.entries["running"] == nil || s.bytes != 0 {
+		t.Errorf("after a sweep %d entries and %d bytes are left, want only the running request", len(s.entries), s.bytes)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MailMessage is a plain text email
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email. The server uses SMTPMailer in production and
+// FileMailer, which only writes messages to disk, for local testing.
+type Mailer interface {
+	Send(ctx context.Context, msg MailMessage) error
+}
+
+var mailer Mailer = FileMailer{Dir: "outbox", From: "myapi@localhost"}
+
+// MailConfig chooses and configures the mailer from the command line
+type MailConfig struct {
+	Kind   string // smtp or file
+	Outbox string
+	SMTP   SMTPMailer
+}
+
+// registerFlags adds the mailer flags to fs
+func (c *MailConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Kind, "mailer", "file", "how verification emails are sent: smtp, or file to write them to -mail-outbox")
+	fs.StringVar(&c.Outbox, "mail-outbox", "outbox", "directory the file mailer writes .eml files to")
+	fs.StringVar(&c.SMTP.From, "mail-from", "myapi@localhost", "sender address of verification emails")
+	fs.StringVar(&c.SMTP.Addr, "smtp-addr", "", "SMTP server host:port")
+	fs.StringVar(&c.SMTP.Username, "smtp-user", "", "SMTP username")
+	fs.StringVar(&c.SMTP.Password, "smtp-password", os.Getenv("MYAPI_SMTP_PASSWORD"), "SMTP password (default $MYAPI_SMTP_PASSWORD)")
+}
+
+// newMailer builds the mailer chosen with -mailer
+func (c MailConfig) newMailer() (Mailer, error) {
+	switch c.Kind {
+	case "file":
+		return FileMailer{Dir: c.Outbox, From: c.SMTP.From}, nil
+	case "smtp":
+		if c.SMTP.Addr == "" || c.SMTP.From == "" {
+			return nil, errors.New("-mailer smtp needs -smtp-addr and -mail-from")
+		}
+		return c.SMTP, nil
+	default:
+		return nil, fmt.Errorf("unknown -mailer %q, use smtp or file", c.Kind)
+	}
+}
+
+// formatMail renders msg as an RFC 5322 message
+func formatMail(from string, msg MailMessage) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// FileMailer writes each message to its own .eml file in Dir
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m FileMailer) Send(ctx context.Context, msg MailMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), randomHex(4))
+	return os.WriteFile(filepath.Join(m.Dir, name), formatMail(m.From, msg), 0644)
+}
+
+// SMTPMailer sends through an SMTP server, upgrading to TLS with STARTTLS
+// when the server offers it. Username and Password are optional.
+type SMTPMailer struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+func (m SMTPMailer) Send(ctx context.Context, msg MailMessage) error {
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(formatMail(m.From, msg)); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
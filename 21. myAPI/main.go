@@ -1,12 +1,16 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -24,11 +28,6 @@ type Response struct {
 	Data    any    `json:"data,omitempty"`
 }
 
-// Global variables
-var users = []User{
-	{ID: 1, Name: "John Doe", Email: "john@example.com", CreatedAt: time.Now().Format(time.RFC3339)},
-}
-
 // Logger function
 func logEndpoint(r *http.Request, startTime time.Time, statusCode int) {
 	duration := time.Since(startTime)
@@ -53,13 +52,18 @@ func handleGetUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	records, err := storeFor(r).List(r.Context())
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+
 	response := Response{
 		Message: "Users retrieved successfully",
 		Status:  http.StatusOK,
-		Data:    users,
+		Data:    usersV1(records),
 	}
 
-	sendJSONResponse(w, response)
+	sendResponse(w, r, response)
 	logEndpoint(r, startTime, http.StatusOK)
 }
 
@@ -74,17 +78,34 @@ func handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var newUser User
-	if err := json.NewDecoder(r.Body).Decode(&newUser); err != nil {
+	if err := decodeBody(r, &newUser); err != nil {
+		if requestAborted(w, r, startTime, err) {
+			return
+		}
+		http.Error(w, err.Error(), decodeStatus(err))
+		logEndpoint(r, startTime, decodeStatus(err))
+		return
+	}
+
+	if err := newUser.Validate(); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		logEndpoint(r, startTime, http.StatusBadRequest)
 		return
 	}
 
-	newUser.ID = getNextUserID()
-	newUser.CreatedAt = time.Now().Format(time.RFC3339)
-	users = append(users, newUser)
-
-	saveUsersToFile()
+	added, err := storeFor(r).Add(r.Context(), newUser)
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		logEndpoint(r, startTime, http.StatusForbidden)
+		return
+	}
+	newUser = added[0].V1()
+	saveUsersToFile(r.Context())
+	requestVerification(r, added[0])
+	publishEvent(r.Context(), EventUserCreated, newUser)
 
 	response := Response{
 		Message: "User created successfully",
@@ -92,8 +113,7 @@ func handleCreateUser(w http.ResponseWriter, r *http.Request) {
 		Data:    newUser,
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	sendJSONResponse(w, response)
+	sendResponse(w, r, response)
 	logEndpoint(r, startTime, http.StatusCreated)
 }
 
@@ -109,20 +129,36 @@ func handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 
 	id := getUserIDFromURL(r.URL.Path, "/api/put/")
 	var updatedUser User
-	if err := json.NewDecoder(r.Body).Decode(&updatedUser); err != nil {
+	if err := decodeBody(r, &updatedUser); err != nil {
+		if requestAborted(w, r, startTime, err) {
+			return
+		}
+		http.Error(w, err.Error(), decodeStatus(err))
+		logEndpoint(r, startTime, decodeStatus(err))
+		return
+	}
+
+	if err := updatedUser.Validate(); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		logEndpoint(r, startTime, http.StatusBadRequest)
 		return
 	}
 
-	if !updateUserByID(id, &updatedUser) {
+	record, err := storeFor(r).Update(r.Context(), id, updatedUser)
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		logEndpoint(r, startTime, http.StatusNotFound)
 		return
 	}
+	updatedUser = record.V1()
 
-	saveUsersToFile()
-	sendJSONResponse(w, Response{
+	saveUsersToFile(r.Context())
+	requestVerification(r, record)
+	publishEvent(r.Context(), EventUserUpdated, updatedUser)
+	sendResponse(w, r, Response{
 		Message: "User updated successfully",
 		Status:  http.StatusOK,
 		Data:    updatedUser,
@@ -141,14 +177,19 @@ func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id := getUserIDFromURL(r.URL.Path, "/api/delete/")
-	if !deleteUserByID(id) {
+	removed, err := storeFor(r).Delete(r.Context(), id)
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		logEndpoint(r, startTime, http.StatusNotFound)
 		return
 	}
 
-	saveUsersToFile()
-	sendJSONResponse(w, Response{
+	saveUsersToFile(r.Context())
+	publishEvent(r.Context(), EventUserDeleted, removed.V1())
+	sendResponse(w, r, Response{
 		Message: "User deleted successfully",
 		Status:  http.StatusOK,
 	})
@@ -166,17 +207,38 @@ func handleFormData(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := r.ParseForm(); err != nil {
+		if requestAborted(w, r, startTime, err) {
+			return
+		}
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		logEndpoint(r, startTime, http.StatusBadRequest)
 		return
 	}
 
 	newUser := createUserFromForm(r)
-	users = append(users, newUser)
-	saveUsersToFile()
+	if err := newUser.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+
+	added, err := storeFor(r).Add(r.Context(), newUser)
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		logEndpoint(r, startTime, http.StatusForbidden)
+		return
+	}
+	newUser = added[0].V1()
+	saveUsersToFile(r.Context())
+	requestVerification(r, added[0])
 	saveFormToFile(newUser)
+	publishEvent(r.Context(), EventUserCreated, newUser)
+	publishEvent(r.Context(), EventFormSubmitted, newUser)
 
-	sendJSONResponse(w, Response{
+	sendResponse(w, r, Response{
 		Message: "Form data processed successfully",
 		Status:  http.StatusOK,
 		Data:    newUser,
@@ -196,19 +258,31 @@ func handleFileUpload(w http.ResponseWriter, r *http.Request) {
 
 	file, handler, err := processFileUpload(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		logEndpoint(r, startTime, http.StatusBadRequest)
+		if requestAborted(w, r, startTime, err) {
+			return
+		}
+		http.Error(w, err.Error(), decodeStatus(err))
+		logEndpoint(r, startTime, decodeStatus(err))
 		return
 	}
 	defer file.Close()
 
-	if err := saveUploadedFile(file, handler); err != nil {
+	if err := saveUploadedFile(r.Context(), file, handler); err != nil {
+		if requestAborted(w, r, startTime, err) {
+			return
+		}
+		if errors.Is(err, errUploadQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			logEndpoint(r, startTime, http.StatusInsufficientStorage)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		logEndpoint(r, startTime, http.StatusInternalServerError)
 		return
 	}
 
-	sendJSONResponse(w, Response{
+	publishEvent(r.Context(), EventFileUploaded, map[string]any{"filename": handler.Filename, "size": handler.Size})
+	sendResponse(w, r, Response{
 		Message: fmt.Sprintf("File %s uploaded successfully", handler.Filename),
 		Status:  http.StatusOK,
 	})
@@ -216,48 +290,16 @@ func handleFileUpload(w http.ResponseWriter, r *http.Request) {
 }
 
 // [Rest of the helper functions remain the same]
-func getNextUserID() int {
-	maxID := 0
-	for _, user := range users {
-		if user.ID > maxID {
-			maxID = user.ID
-		}
-	}
-	return maxID + 1
-}
+const uploadsDir = "uploads"
 
 func getUserIDFromURL(path, prefix string) string {
 	return path[len(prefix):]
 }
 
-func updateUserByID(id string, updatedUser *User) bool {
-	for i, user := range users {
-		if fmt.Sprint(user.ID) == id {
-			updatedUser.ID = user.ID
-			updatedUser.CreatedAt = user.CreatedAt
-			users[i] = *updatedUser
-			return true
-		}
-	}
-	return false
-}
-
-func deleteUserByID(id string) bool {
-	for i, user := range users {
-		if fmt.Sprint(user.ID) == id {
-			users = append(users[:i], users[i+1:]...)
-			return true
-		}
-	}
-	return false
-}
-
 func createUserFromForm(r *http.Request) User {
 	return User{
-		ID:        getNextUserID(),
-		Name:      r.FormValue("name"),
-		Email:     r.FormValue("email"),
-		CreatedAt: time.Now().Format(time.RFC3339),
+		Name:  r.FormValue("name"),
+		Email: r.FormValue("email"),
 	}
 }
 
@@ -268,21 +310,36 @@ func processFileUpload(r *http.Request) (multipart.File, *multipart.FileHeader,
 	return r.FormFile("file")
 }
 
-func saveUploadedFile(file multipart.File, handler *multipart.FileHeader) error {
-	os.MkdirAll("uploads", os.ModePerm)
-	dst, err := os.Create(fmt.Sprintf("uploads/%s", handler.Filename))
+// saveUploadedFile stores an upload in the tenant's upload directory
+func saveUploadedFile(ctx context.Context, file multipart.File, handler *multipart.FileHeader) error {
+	tenant := tenantFrom(ctx)
+	if err := tenant.checkUploadQuota(handler.Filename, handler.Size); err != nil {
+		return err
+	}
+	os.MkdirAll(tenant.uploads, os.ModePerm)
+	path := fmt.Sprintf("%s/%s", tenant.uploads, handler.Filename)
+	dst, err := os.Create(path)
 	if err != nil {
 		return err
 	}
-	defer dst.Close()
 
-	_, err = io.Copy(dst, file)
+	_, err = io.Copy(dst, contextReader{ctx, file})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Don't leave a partial file behind
+		os.Remove(path)
+	}
 	return err
 }
 
-func saveUsersToFile() {
-	data, _ := json.MarshalIndent(users, "", "    ")
-	os.WriteFile("users.json", data, 0644)
+// saveUsersToFile persists a change that is already in memory. It finishes
+// even if the request is cancelled, so the file never falls behind the store.
+func saveUsersToFile(ctx context.Context) {
+	if err := tenantFrom(ctx).store.Save(context.WithoutCancel(ctx)); err != nil {
+		fmt.Printf("Error saving users: %s\n", err)
+	}
 }
 
 func saveFormToFile(user User) {
@@ -291,25 +348,191 @@ func saveFormToFile(user User) {
 	os.WriteFile("form_submissions.txt", []byte(formData), 0644)
 }
 
-func sendJSONResponse(w http.ResponseWriter, response Response) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// Route describes one registered endpoint and the method it accepts.
+// Several routes may share a pattern; other methods get 405.
+type Route struct {
+	Method  string
+	Pattern string
+	Handler http.HandlerFunc
+}
+
+// v1Routes are the original user endpoints. Each is served at its
+// unversioned /api path and under /api/v1, both marked deprecated.
+func v1Routes() []Route {
+	return []Route{
+		{http.MethodGet, "/api/get", negotiated(handleGetUsers)},
+		{http.MethodPost, "/api/post", idempotent(negotiated(handleCreateUser))},
+		{http.MethodPut, "/api/put/", negotiated(handleUpdateUser)},
+		{http.MethodDelete, "/api/delete/", negotiated(handleDeleteUser)},
+		{http.MethodPost, "/api/form", idempotent(negotiated(handleFormData))},
+		{http.MethodPost, "/api/upload", idempotent(negotiated(handleFileUpload))},
+		{http.MethodGet, "/api/users/export", handleExportUsers},
+		{http.MethodPost, "/api/users/import", negotiated(handleImportUsers)},
+		{http.MethodGet, "/api/users/search", negotiated(handleSearchUsers)},
+	}
+}
+
+func apiRoutes() []Route {
+	var routes []Route
+	for _, route := range v1Routes() {
+		routes = append(routes,
+			Route{route.Method, route.Pattern, deprecatedV1(route.Handler)},
+			Route{route.Method, "/api/v1" + strings.TrimPrefix(route.Pattern, "/api"), deprecatedV1(v1Alias(route.Handler))},
+		)
+	}
+	return append(routes, []Route{
+		{http.MethodGet, "/api/v2/users", negotiated(handleListUsersV2)},
+		{http.MethodPost, "/api/v2/users", idempotent(negotiated(handleCreateUserV2))},
+		{http.MethodGet, "/api/v2/users/", negotiated(handleGetUserV2)},
+		{http.MethodPut, "/api/v2/users/", negotiated(handleUpdateUserV2)},
+		{http.MethodDelete, "/api/v2/users/", negotiated(handleDeleteUserV2)},
+		{http.MethodGet, "/api/events", handleEventStream},
+		{http.MethodGet, "/api/webhooks", negotiated(handleListWebhooks)},
+		{http.MethodPost, "/api/webhooks", negotiated(handleCreateWebhook)},
+		{http.MethodDelete, "/api/webhooks/", negotiated(handleDeleteWebhook)},
+		{http.MethodGet, "/api/webhooks/dead-letters", negotiated(handleListDeadLetters)},
+		{http.MethodPost, "/api/webhooks/dead-letters/", negotiated(handleRequeueDeadLetter)},
+		{http.MethodGet, "/api/admin/tenants", adminOnly(negotiated(handleListTenants))},
+		{http.MethodPost, "/api/admin/tenants", adminOnly(negotiated(handleCreateTenant))},
+		{http.MethodPatch, "/api/admin/tenants/", adminOnly(negotiated(handleUpdateTenant))},
+		{http.MethodDelete, "/api/admin/tenants/", adminOnly(negotiated(handleDeleteTenant))},
+		{http.MethodGet, "/api/verify", negotiated(handleVerifyEmail)},
+		{http.MethodGet, "/healthz", handleHealthz},
+		{http.MethodGet, "/readyz", handleReadyz},
+		{http.MethodGet, "/openapi.json", handleOpenAPISpec},
+		{http.MethodGet, "/docs", handleAPIDocs},
+	}...)
 }
 
 func setupRoutes() {
 	fmt.Println("Setting up routes...")
-	http.HandleFunc("/api/get", handleGetUsers)
-	http.HandleFunc("/api/post", handleCreateUser)
-	http.HandleFunc("/api/put/", handleUpdateUser)
-	http.HandleFunc("/api/delete/", handleDeleteUser)
-	http.HandleFunc("/api/form", handleFormData)
-	http.HandleFunc("/api/upload", handleFileUpload)
+	var patterns []string
+	methods := map[string]map[string]http.HandlerFunc{}
+	for _, route := range append(apiRoutes(), adminUIRoutes()...) {
+		if methods[route.Pattern] == nil {
+			methods[route.Pattern] = map[string]http.HandlerFunc{}
+			patterns = append(patterns, route.Pattern)
+		}
+		methods[route.Pattern][route.Method] = route.Handler
+	}
+	for _, pattern := range patterns {
+		http.HandleFunc(pattern, withDeadline(routeTimeout(pattern), methodHandler(methods[pattern])))
+	}
+}
+
+// methodHandler dispatches to the handler registered for the request method
+func methodHandler(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	var allowed []string
+	for method := range handlers {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if handler, ok := handlers[r.Method]; ok {
+			handler(w, r)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logEndpoint(r, time.Now(), http.StatusMethodNotAllowed)
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
+	cfg, mailCfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(2)
+	}
+	if mailer, err = mailCfg.newMailer(); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(2)
+	}
+	if publicBaseURL == "" {
+		fmt.Println("Warning: no -public-url set, verification emails will not be sent")
+	}
+	if verifySecret == "" {
+		verifySecret = randomHex(32)
+		fmt.Println("Warning: no -verify-secret set, verification links will stop working on restart")
+	}
+
+	if err := checkOpenAPIRoutes(buildOpenAPISpec(), apiRoutes()); err != nil {
+		fmt.Printf("Warning: %s\n", err)
+	}
+
+	if err := store.Load(); err != nil {
+		fmt.Printf("Error loading users: %s\n", err)
+	}
+	if err := tenants.Load(); err != nil {
+		fmt.Printf("Error loading tenants: %s\n", err)
+	}
+	if err := webhooks.Load(); err != nil {
+		fmt.Printf("Error loading webhooks: %s\n", err)
+	}
+	stop := make(chan struct{})
+	webhooksStopped := make(chan struct{})
+	go func() {
+		webhooks.Run(stop)
+		close(webhooksStopped)
+	}()
+	go idempotencyKeys.Run(stop)
+	registerHealthChecks()
+
 	setupRoutes()
-	fmt.Println("Server starting on port 8080...")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := serve(cfg, withCompression(withTenant(http.DefaultServeMux))); err != nil {
 		fmt.Printf("Error starting server: %s\n", err)
 	}
+
+	// Let deliveries in flight finish and save the webhook queue
+	close(stop)
+	<-webhooksStopped
+}
+
+// parseFlags reads the server settings and those of each feature from the
+// command line. It only checks them; main acts on them.
+func parseFlags(args []string) (ServerConfig, MailConfig, error) {
+	var cfg ServerConfig
+	var mailCfg MailConfig
+	fs := flag.NewFlagSet("myapi", flag.ContinueOnError)
+	cfg.registerFlags(fs)
+	registerTenantFlags(fs)
+	registerTimeoutFlags(fs)
+	registerHealthFlags(fs)
+	registerIdempotencyFlags(fs)
+	mailCfg.registerFlags(fs)
+	registerVerifyFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return cfg, mailCfg, err
+	}
+
+	if err := cfg.check(); err != nil {
+		return cfg, mailCfg, err
+	}
+	return cfg, mailCfg, checkPublicURL()
+}
+
+// runCommand runs a maintenance subcommand instead of the server
+func runCommand(name string, args []string) {
+	switch name {
+	case "check-openapi":
+		if err := checkOpenAPIRoutes(buildOpenAPISpec(), apiRoutes()); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("OpenAPI document matches the registered routes")
+	case "gen-cert":
+		if err := runGenCert(args); err != nil {
+			fmt.Printf("Error generating certificates: %s\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Unknown command %q\n", name)
+		os.Exit(2)
+	}
 }
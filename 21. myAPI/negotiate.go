@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Codec serializes the Response envelope in one format and, where the
+// format makes sense for request bodies, decodes them
+type Codec struct {
+	Name       string
+	MediaTypes []string // the first one is sent as Content-Type
+	Encode     func(io.Writer, any) error
+	Decode     func(io.Reader, any) error
+}
+
+var codecs = []Codec{
+	{
+		Name:       "json",
+		MediaTypes: []string{"application/json"},
+		Encode:     func(w io.Writer, v any) error { return json.NewEncoder(w).Encode(v) },
+		Decode:     func(r io.Reader, v any) error { return json.NewDecoder(r).Decode(v) },
+	},
+	{Name: "xml", MediaTypes: []string{"application/xml", "text/xml"}, Encode: encodeXML, Decode: decodeXML},
+	{Name: "yaml", MediaTypes: []string{"application/yaml", "application/x-yaml", "text/yaml"}, Encode: encodeYAML, Decode: decodeYAML},
+	{Name: "csv", MediaTypes: []string{"text/csv"}, Encode: encodeCSV, Decode: decodeCSV},
+	{Name: "msgpack", MediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}, Encode: encodeMsgpack, Decode: decodeMsgpack},
+}
+
+// formCodec decodes url-encoded bodies; it is never chosen for responses
+var formCodec = Codec{Name: "form", MediaTypes: []string{"application/x-www-form-urlencoded"}, Decode: decodeForm}
+
+var errUnsupportedMediaType = errors.New("unsupported Content-Type")
+
+type codecKey struct{}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns the media ranges of an Accept header, best first
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(raw, 64); err == nil {
+				q = parsed
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+
+	// Higher quality first, then more specific ranges, then header order
+	specificity := func(mediaType string) int {
+		switch {
+		case mediaType == "*/*":
+			return 0
+		case strings.HasSuffix(mediaType, "/*"):
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType)
+	})
+	return ranges
+}
+
+func (c Codec) matches(mediaRange string) bool {
+	for _, mediaType := range c.MediaTypes {
+		if mediaRange == "*/*" || mediaRange == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(mediaRange, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateCodec picks the response codec from ?format= or the Accept header
+func negotiateCodec(r *http.Request) (Codec, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		for _, codec := range codecs {
+			if codec.Name == format {
+				return codec, true
+			}
+		}
+		return Codec{}, false
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return codecs[0], true
+	}
+
+	ranges := parseAccept(accept)
+	for _, rng := range ranges {
+		if rng.q <= 0 {
+			continue
+		}
+		for _, codec := range codecs {
+			if !codec.matches(rng.mediaType) {
+				continue
+			}
+			// A q=0 entry for a more specific range excludes the codec
+			excluded := false
+			for _, other := range ranges {
+				if other.q <= 0 && other.mediaType != "*/*" && codec.matches(other.mediaType) {
+					excluded = true
+				}
+			}
+			if !excluded {
+				return codec, true
+			}
+		}
+	}
+	return Codec{}, false
+}
+
+// negotiated resolves the response codec before the handler runs, so an
+// unacceptable request is refused with 406 before it changes anything
+func negotiated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		codec, ok := negotiateCodec(r)
+		if !ok {
+			var offered []string
+			for _, c := range codecs {
+				offered = append(offered, c.MediaTypes[0])
+			}
+			http.Error(w, "Not Acceptable, available types: "+strings.Join(offered, ", "), http.StatusNotAcceptable)
+			logEndpoint(r, time.Now(), http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		handler(w, r.WithContext(context.WithValue(r.Context(), codecKey{}, codec)))
+	}
+}
+
+// sendResponse writes the envelope in the negotiated format
+func sendResponse(w http.ResponseWriter, r *http.Request, response Response) {
+	codec, ok := r.Context().Value(codecKey{}).(Codec)
+	if !ok {
+		codec = codecs[0]
+	}
+	w.Header().Set("Content-Type", codec.MediaTypes[0])
+	w.WriteHeader(response.Status)
+	codec.Encode(w, response)
+}
+
+// decodeBody decodes the request body according to its Content-Type; a
+// missing Content-Type is treated as JSON
+func decodeBody(r *http.Request, v any) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return codecs[0].Decode(r.Body, v)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return errUnsupportedMediaType
+	}
+
+	for _, codec := range append(codecs[:len(codecs):len(codecs)], formCodec) {
+		for _, candidate := range codec.MediaTypes {
+			if candidate == mediaType {
+				return codec.Decode(r.Body, v)
+			}
+		}
+	}
+	return errUnsupportedMediaType
+}
+
+// decodeStatus maps a body reading error to its HTTP status
+func decodeStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, errDecompressedTooLarge), errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,1047 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpenAPI 3.1 document structures
+type OpenAPI struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps a lower-case HTTP method to its operation
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Summary     string              `json:"summary"`
+	OperationID string              `json:"operationId"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]APIReply `json:"responses"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required"`
+	Schema      Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema Schema `json:"schema"`
+}
+
+// APIReply is an OpenAPI response object, or a $ref to a shared one
+type APIReply struct {
+	Ref         string               `json:"$ref,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas   map[string]Schema   `json:"schemas"`
+	Responses map[string]APIReply `json:"responses"`
+}
+
+// Schema is a JSON Schema object
+type Schema map[string]any
+
+func ref(name string) Schema {
+	return Schema{"$ref": "#/components/schemas/" + name}
+}
+
+func replyRef(name string) APIReply {
+	return APIReply{Ref: "#/components/responses/" + name}
+}
+
+func jsonReply(description string, data Schema) APIReply {
+	schema := ref("Response")
+	if data != nil {
+		schema = Schema{"allOf": []Schema{ref("Response"), {"properties": Schema{"data": data}}}}
+	}
+	return APIReply{
+		Description: description,
+		Content:     map[string]MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+func textError(description string) APIReply {
+	return APIReply{
+		Description: description,
+		Content:     map[string]MediaType{"text/plain": {Schema: ref("Error")}},
+	}
+}
+
+var idParameter = Parameter{Name: "id", In: "path", Description: "User ID", Required: true, Schema: Schema{"type": "string"}}
+
+var idempotencyKeyParameter = Parameter{Name: "Idempotency-Key", In: "header", Description: "Unique key for this request; retries with the same key and body get the first response again, with an Idempotent-Replayed header", Schema: Schema{"type": "string", "maxLength": maxIdempotencyKeySize}}
+
+var tenantIDParameter = Parameter{Name: "id", In: "path", Description: "Tenant ID", Required: true, Schema: Schema{"type": "string"}}
+
+var tenantHeaderParameter = Parameter{Name: "X-Tenant-ID", In: "header", Description: "Tenant to act for, honoured only when the server runs with -trust-tenant-header; otherwise a bearer token's tenant claim names the tenant", Schema: Schema{"type": "string"}}
+
+var publicIDParameter = Parameter{Name: "id", In: "path", Description: "Opaque user ID, such as usr_0123456789abcdef01234567", Required: true, Schema: Schema{"type": "string"}}
+
+// buildOpenAPISpec describes every route registered in apiRoutes
+func buildOpenAPISpec() OpenAPI {
+	userBody := &RequestBody{
+		Required: true,
+		Content:  map[string]MediaType{"application/json": {Schema: ref("UserInput")}},
+	}
+
+	spec := OpenAPI{
+		OpenAPI: "3.1.0",
+		Info: Info{
+			Title:       "myAPI",
+			Version:     "2.0.0",
+			Description: "User management API. Every user, upload and webhook belongs to a tenant, chosen by a bearer token's tenant claim, or by the X-Tenant-ID header or the subdomain when the server trusts them (-trust-tenant-header); requests naming none use the default tenant. The original endpoints are also served under /api/v1 and are deprecated in favour of /api/v2; their responses carry a Deprecation header and a successor-version Link. Errors are returned as plain text bodies. Responses are gzip or deflate encoded when the client sends Accept-Encoding, and gzip or deflate request bodies are accepted with Content-Encoding.",
+		},
+		Paths: map[string]PathItem{
+			"/api/get": {
+				"get": {
+					Summary:     "List all users",
+					OperationID: "getUsers",
+					Tags:        []string{"users"},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Users retrieved successfully", Schema{"type": "array", "items": ref("User")}),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/post": {
+				"post": {
+					Summary:     "Create a user",
+					OperationID: "createUser",
+					Tags:        []string{"users"},
+					RequestBody: userBody,
+					Responses: map[string]APIReply{
+						"201": jsonReply("User created successfully", ref("User")),
+						"400": replyRef("BadRequest"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/put/{id}": {
+				"put": {
+					Summary:     "Update a user",
+					OperationID: "updateUser",
+					Tags:        []string{"users"},
+					Parameters:  []Parameter{idParameter},
+					RequestBody: userBody,
+					Responses: map[string]APIReply{
+						"200": jsonReply("User updated successfully", ref("User")),
+						"400": replyRef("BadRequest"),
+						"404": replyRef("NotFound"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/delete/{id}": {
+				"delete": {
+					Summary:     "Delete a user",
+					OperationID: "deleteUser",
+					Tags:        []string{"users"},
+					Parameters:  []Parameter{idParameter},
+					Responses: map[string]APIReply{
+						"200": jsonReply("User deleted successfully", nil),
+						"404": replyRef("NotFound"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/form": {
+				"post": {
+					Summary:     "Create a user from form data",
+					OperationID: "submitForm",
+					Tags:        []string{"users"},
+					RequestBody: &RequestBody{
+						Required: true,
+						Content:  map[string]MediaType{"application/x-www-form-urlencoded": {Schema: ref("UserInput")}},
+					},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Form data processed successfully", ref("User")),
+						"400": replyRef("BadRequest"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/upload": {
+				"post": {
+					Summary:     "Upload a file",
+					OperationID: "uploadFile",
+					Tags:        []string{"uploads"},
+					RequestBody: &RequestBody{
+						Required: true,
+						Content: map[string]MediaType{"multipart/form-data": {Schema: Schema{
+							"type":       "object",
+							"required":   []string{"file"},
+							"properties": Schema{"file": Schema{"type": "string", "contentMediaType": "application/octet-stream"}},
+						}}},
+					},
+					Responses: map[string]APIReply{
+						"200": jsonReply("File uploaded successfully", nil),
+						"400": replyRef("BadRequest"),
+						"405": replyRef("MethodNotAllowed"),
+						"500": textError("The file could not be stored"),
+						"507": textError("The tenant's upload quota would be exceeded"),
+					},
+				},
+			},
+			"/api/users/export": {
+				"get": {
+					Summary:     "Stream all users as CSV, NDJSON or JSON",
+					OperationID: "exportUsers",
+					Tags:        []string{"users"},
+					Parameters: []Parameter{{
+						Name: "format", In: "query", Description: "Output format",
+						Schema: Schema{"type": "string", "enum": []string{"csv", "ndjson", "json"}, "default": "json"},
+					}},
+					Responses: map[string]APIReply{
+						"200": {
+							Description: "Users in the requested format",
+							Content: map[string]MediaType{
+								"text/csv":             {Schema: Schema{"type": "string"}},
+								"application/x-ndjson": {Schema: Schema{"type": "string"}},
+								"application/json":     {Schema: Schema{"type": "array", "items": ref("User")}},
+							},
+						},
+						"400": replyRef("BadRequest"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/users/import": {
+				"post": {
+					Summary:     "Import users from CSV",
+					OperationID: "importUsers",
+					Tags:        []string{"users"},
+					Parameters: []Parameter{{
+						Name: "columns", In: "query", Description: `Header mapping such as "name:Full Name,email:Mail"`,
+						Schema: Schema{"type": "string"},
+					}},
+					RequestBody: &RequestBody{
+						Required: true,
+						Content: map[string]MediaType{
+							"text/csv": {Schema: Schema{"type": "string"}},
+							"multipart/form-data": {Schema: Schema{
+								"type":       "object",
+								"required":   []string{"file"},
+								"properties": Schema{"file": Schema{"type": "string", "contentMediaType": "text/csv"}},
+							}},
+						},
+					},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Import report", ref("ImportReport")),
+						"400": replyRef("BadRequest"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/users/search": {
+				"get": {
+					Summary:     "Search users by name and email",
+					OperationID: "searchUsers",
+					Tags:        []string{"users"},
+					Parameters: []Parameter{
+						{Name: "q", In: "query", Description: "Words to match; prefixes and small typos are allowed", Required: true, Schema: Schema{"type": "string"}},
+						{Name: "limit", In: "query", Description: "Maximum number of hits", Schema: Schema{"type": "integer", "minimum": 1, "maximum": maxSearchLimit, "default": defaultSearchLimit}},
+					},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Ranked search hits", Schema{"type": "array", "items": ref("SearchHit")}),
+						"400": replyRef("BadRequest"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/v2/users": {
+				"get": {
+					Summary:     "List users",
+					OperationID: "listUsersV2",
+					Tags:        []string{"users v2"},
+					Parameters: []Parameter{
+						{Name: "sort", In: "query", Description: "Sort key, prefix with - for descending", Schema: Schema{"type": "string", "enum": []string{"created_at", "-created_at", "updated_at", "-updated_at", "name", "-name"}, "default": "created_at"}},
+						{Name: "created_after", In: "query", Description: "Only users created after this time", Schema: Schema{"type": "string", "format": "date-time"}},
+						{Name: "created_before", In: "query", Description: "Only users created before this time", Schema: Schema{"type": "string", "format": "date-time"}},
+					},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Users retrieved successfully", Schema{"type": "array", "items": ref("UserV2")}),
+						"400": replyRef("BadRequest"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+				"post": {
+					Summary:     "Create a user",
+					OperationID: "createUserV2",
+					Tags:        []string{"users v2"},
+					RequestBody: userBody,
+					Responses: map[string]APIReply{
+						"201": jsonReply("User created successfully; Location points at the new user", ref("UserV2")),
+						"400": replyRef("BadRequest"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/v2/users/{id}": {
+				"get": {
+					Summary:     "Get a user",
+					OperationID: "getUserV2",
+					Tags:        []string{"users v2"},
+					Parameters:  []Parameter{publicIDParameter},
+					Responses: map[string]APIReply{
+						"200": jsonReply("User retrieved successfully", ref("UserV2")),
+						"404": replyRef("NotFound"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+				"put": {
+					Summary:     "Update a user",
+					OperationID: "updateUserV2",
+					Tags:        []string{"users v2"},
+					Parameters:  []Parameter{publicIDParameter},
+					RequestBody: userBody,
+					Responses: map[string]APIReply{
+						"200": jsonReply("User updated successfully", ref("UserV2")),
+						"400": replyRef("BadRequest"),
+						"404": replyRef("NotFound"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+				"delete": {
+					Summary:     "Delete a user",
+					OperationID: "deleteUserV2",
+					Tags:        []string{"users v2"},
+					Parameters:  []Parameter{publicIDParameter},
+					Responses: map[string]APIReply{
+						"200": jsonReply("User deleted successfully", nil),
+						"404": replyRef("NotFound"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/events": {
+				"get": {
+					Summary:     "Stream change events over Server-Sent Events or WebSocket",
+					OperationID: "streamEvents",
+					Tags:        []string{"events"},
+					Parameters: []Parameter{
+						{Name: "Last-Event-ID", In: "header", Description: "Resume after this event ID, replaying the buffered events since; without it the stream starts with the next event", Schema: Schema{"type": "integer"}},
+						{Name: "last_event_id", In: "query", Description: "Resume after this event ID, for clients that cannot set headers", Schema: Schema{"type": "integer"}},
+					},
+					Responses: map[string]APIReply{
+						"101": {Description: "WebSocket connection; each text message is a ChangeEvent"},
+						"200": {Description: "Server-Sent Events stream of ChangeEvent data", Content: map[string]MediaType{"text/event-stream": {Schema: ref("ChangeEvent")}}},
+						"400": replyRef("BadRequest"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/webhooks": {
+				"get": {
+					Summary:     "List webhook subscriptions",
+					OperationID: "listWebhooks",
+					Tags:        []string{"webhooks"},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Webhooks retrieved successfully", Schema{"type": "array", "items": ref("WebhookSubscription")}),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+				"post": {
+					Summary:     "Subscribe to events",
+					OperationID: "createWebhook",
+					Tags:        []string{"webhooks"},
+					RequestBody: &RequestBody{
+						Required: true,
+						Content:  map[string]MediaType{"application/json": {Schema: ref("WebhookInput")}},
+					},
+					Responses: map[string]APIReply{
+						"201": jsonReply("Webhook created; the secret is only returned here", ref("WebhookSubscription")),
+						"400": replyRef("BadRequest"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/webhooks/{id}": {
+				"delete": {
+					Summary:     "Delete a webhook subscription",
+					OperationID: "deleteWebhook",
+					Tags:        []string{"webhooks"},
+					Parameters:  []Parameter{{Name: "id", In: "path", Description: "Subscription ID", Required: true, Schema: Schema{"type": "string"}}},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Webhook deleted successfully", nil),
+						"404": textError("Webhook not found"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/webhooks/dead-letters": {
+				"get": {
+					Summary:     "List deliveries that ran out of attempts",
+					OperationID: "listDeadLetters",
+					Tags:        []string{"webhooks"},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Dead letters retrieved successfully", Schema{"type": "array", "items": ref("WebhookDelivery")}),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/webhooks/dead-letters/{id}": {
+				"post": {
+					Summary:     "Requeue a dead letter",
+					OperationID: "requeueDeadLetter",
+					Tags:        []string{"webhooks"},
+					Parameters:  []Parameter{{Name: "id", In: "path", Description: "Delivery ID", Required: true, Schema: Schema{"type": "string"}}},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Delivery requeued successfully", nil),
+						"404": textError("Dead letter not found"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/admin/tenants": {
+				"get": {
+					Summary:     "List tenants",
+					OperationID: "listTenants",
+					Tags:        []string{"admin"},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Tenants retrieved successfully", Schema{"type": "array", "items": ref("Tenant")}),
+						"401": replyRef("Unauthorized"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+				"post": {
+					Summary:     "Create a tenant",
+					OperationID: "createTenant",
+					Tags:        []string{"admin"},
+					RequestBody: &RequestBody{
+						Required: true,
+						Content:  map[string]MediaType{"application/json": {Schema: ref("TenantInput")}},
+					},
+					Responses: map[string]APIReply{
+						"201": jsonReply("Tenant created successfully", ref("Tenant")),
+						"400": replyRef("BadRequest"),
+						"401": replyRef("Unauthorized"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/admin/tenants/{id}": {
+				"patch": {
+					Summary:     "Rename, suspend, resume or change the quotas of a tenant",
+					OperationID: "updateTenant",
+					Tags:        []string{"admin"},
+					Parameters:  []Parameter{tenantIDParameter},
+					RequestBody: &RequestBody{
+						Required: true,
+						Content:  map[string]MediaType{"application/json": {Schema: ref("TenantPatch")}},
+					},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Tenant updated successfully", ref("Tenant")),
+						"400": replyRef("BadRequest"),
+						"401": replyRef("Unauthorized"),
+						"404": textError("Tenant not found"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+				"delete": {
+					Summary:     "Delete a tenant with all its users, uploads and webhooks",
+					OperationID: "deleteTenant",
+					Tags:        []string{"admin"},
+					Parameters:  []Parameter{tenantIDParameter},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Tenant deleted successfully", nil),
+						"400": replyRef("BadRequest"),
+						"401": replyRef("Unauthorized"),
+						"404": textError("Tenant not found"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/api/verify": {
+				"get": {
+					Summary:     "Verify an email address with the token from a verification email",
+					OperationID: "verifyEmail",
+					Tags:        []string{"users v2"},
+					Parameters: []Parameter{
+						{Name: "token", In: "query", Required: true, Description: "Signed token from the link in the email", Schema: Schema{"type": "string"}},
+					},
+					Responses: map[string]APIReply{
+						"200": jsonReply("Email address verified", ref("UserV2")),
+						"400": replyRef("BadRequest"),
+						"403": textError("The tenant is suspended"),
+						"404": replyRef("NotFound"),
+						"409": textError("The email address is already verified; the link was used before"),
+						"410": textError("The link has expired, in which case a new one is sent, or the user's email address has changed"),
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/healthz": {
+				"get": {
+					Summary:     "Liveness probe",
+					OperationID: "getHealth",
+					Tags:        []string{"health"},
+					Responses: map[string]APIReply{
+						"200": {Description: "The process is alive", Content: map[string]MediaType{"application/json": {Schema: ref("HealthReport")}}},
+						"503": {Description: "A liveness check is failing", Content: map[string]MediaType{"application/json": {Schema: ref("HealthReport")}}},
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/readyz": {
+				"get": {
+					Summary:     "Readiness probe: user store, uploads disk space and background workers",
+					OperationID: "getReadiness",
+					Tags:        []string{"health"},
+					Responses: map[string]APIReply{
+						"200": {Description: "Ready to serve traffic", Content: map[string]MediaType{"application/json": {Schema: ref("HealthReport")}}},
+						"503": {Description: "At least one check is failing", Content: map[string]MediaType{"application/json": {Schema: ref("HealthReport")}}},
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/openapi.json": {
+				"get": {
+					Summary:     "This OpenAPI document",
+					OperationID: "getOpenAPI",
+					Tags:        []string{"docs"},
+					Responses: map[string]APIReply{
+						"200": {Description: "OpenAPI 3.1 document", Content: map[string]MediaType{"application/json": {Schema: Schema{"type": "object"}}}},
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+			"/docs": {
+				"get": {
+					Summary:     "HTML documentation",
+					OperationID: "getDocs",
+					Tags:        []string{"docs"},
+					Responses: map[string]APIReply{
+						"200": {Description: "Documentation page", Content: map[string]MediaType{"text/html": {Schema: Schema{"type": "string"}}}},
+						"405": replyRef("MethodNotAllowed"),
+					},
+				},
+			},
+		},
+		Components: Components{
+			Schemas: map[string]Schema{
+				"User": {
+					"type":     "object",
+					"required": []string{"id", "name", "email", "created_at"},
+					"properties": Schema{
+						"id":         Schema{"type": "integer"},
+						"name":       Schema{"type": "string", "maxLength": maxNameLength},
+						"email":      Schema{"type": "string", "format": "email"},
+						"created_at": Schema{"type": "string", "format": "date-time"},
+					},
+				},
+				"UserV2": {
+					"type":     "object",
+					"required": []string{"id", "name", "email", "status", "created_at", "updated_at"},
+					"properties": Schema{
+						"id":          Schema{"type": "string", "description": "Opaque ID"},
+						"name":        Schema{"type": "string", "maxLength": maxNameLength},
+						"email":       Schema{"type": "string", "format": "email"},
+						"status":      Schema{"type": "string", "enum": []string{UserPending, UserActive}, "description": "pending until the email address is verified"},
+						"verified_at": Schema{"type": "string", "format": "date-time"},
+						"created_at":  Schema{"type": "string", "format": "date-time"},
+						"updated_at":  Schema{"type": "string", "format": "date-time"},
+					},
+				},
+				"UserInput": {
+					"type":     "object",
+					"required": []string{"name", "email"},
+					"properties": Schema{
+						"name":  Schema{"type": "string", "minLength": 1, "maxLength": maxNameLength},
+						"email": Schema{"type": "string", "format": "email"},
+					},
+				},
+				"Response": {
+					"type":     "object",
+					"required": []string{"message", "status"},
+					"properties": Schema{
+						"message": Schema{"type": "string"},
+						"status":  Schema{"type": "integer"},
+						"data":    Schema{},
+					},
+				},
+				"ImportReport": {
+					"type": "object",
+					"properties": Schema{
+						"imported": Schema{"type": "integer"},
+						"rejected": Schema{"type": "array", "items": ref("RejectedRow")},
+					},
+				},
+				"RejectedRow": {
+					"type": "object",
+					"properties": Schema{
+						"line":   Schema{"type": "integer"},
+						"error":  Schema{"type": "string"},
+						"record": Schema{"type": "array", "items": Schema{"type": "string"}},
+					},
+				},
+				"SearchHit": {
+					"type": "object",
+					"properties": Schema{
+						"user":  ref("User"),
+						"score": Schema{"type": "number"},
+						"highlights": Schema{
+							"type":        "object",
+							"description": "Name and email with matched words wrapped in <mark>, HTML-escaped",
+							"properties":  Schema{"name": Schema{"type": "string"}, "email": Schema{"type": "string"}},
+						},
+					},
+				},
+				"ChangeEvent": {
+					"type": "object",
+					"properties": Schema{
+						"id":    Schema{"type": "integer"},
+						"event": Schema{"type": "string", "enum": append([]string{EventStreamReset}, webhookEvents...)},
+						"time":  Schema{"type": "string", "format": "date-time"},
+						"data":  Schema{},
+					},
+				},
+				"WebhookInput": {
+					"type":     "object",
+					"required": []string{"url", "events"},
+					"properties": Schema{
+						"url":    Schema{"type": "string", "format": "uri"},
+						"events": Schema{"type": "array", "items": Schema{"type": "string", "enum": append([]string{"*"}, webhookEvents...)}},
+						"secret": Schema{"type": "string", "description": "HMAC secret; generated when omitted"},
+					},
+				},
+				"WebhookSubscription": {
+					"type": "object",
+					"properties": Schema{
+						"id":         Schema{"type": "string"},
+						"url":        Schema{"type": "string", "format": "uri"},
+						"events":     Schema{"type": "array", "items": Schema{"type": "string"}},
+						"secret":     Schema{"type": "string"},
+						"created_at": Schema{"type": "string", "format": "date-time"},
+						"tenant_id":  Schema{"type": "string"},
+					},
+				},
+				"WebhookDelivery": {
+					"type": "object",
+					"properties": Schema{
+						"id":              Schema{"type": "string"},
+						"subscription_id": Schema{"type": "string"},
+						"tenant_id":       Schema{"type": "string"},
+						"event":           Schema{"type": "string"},
+						"payload":         Schema{"type": "object"},
+						"attempts":        Schema{"type": "integer"},
+						"next_attempt":    Schema{"type": "string", "format": "date-time"},
+						"last_error":      Schema{"type": "string"},
+					},
+				},
+				"HealthReport": {
+					"type": "object",
+					"properties": Schema{
+						"status": Schema{"type": "string", "enum": []string{"ok", "unavailable"}},
+						"checks": Schema{"type": "array", "items": ref("CheckResult")},
+					},
+				},
+				"CheckResult": {
+					"type": "object",
+					"properties": Schema{
+						"name":       Schema{"type": "string"},
+						"status":     Schema{"type": "string", "enum": []string{"ok", "failing"}},
+						"latency_ms": Schema{"type": "number"},
+						"error":      Schema{"type": "string"},
+					},
+				},
+				"TenantQuotas": {
+					"type":        "object",
+					"description": "Zero means unlimited",
+					"properties": Schema{
+						"max_users":        Schema{"type": "integer", "minimum": 0},
+						"max_upload_bytes": Schema{"type": "integer", "minimum": 0},
+					},
+				},
+				"Tenant": {
+					"type": "object",
+					"properties": Schema{
+						"id":         Schema{"type": "string", "pattern": tenantIDPattern.String()},
+						"name":       Schema{"type": "string"},
+						"status":     Schema{"type": "string", "enum": []string{TenantActive, TenantSuspended}},
+						"quotas":     ref("TenantQuotas"),
+						"created_at": Schema{"type": "string", "format": "date-time"},
+					},
+				},
+				"TenantInput": {
+					"type":     "object",
+					"required": []string{"id"},
+					"properties": Schema{
+						"id":     Schema{"type": "string", "pattern": tenantIDPattern.String()},
+						"name":   Schema{"type": "string"},
+						"quotas": ref("TenantQuotas"),
+					},
+				},
+				"TenantPatch": {
+					"type":        "object",
+					"description": "Set status to suspended to suspend a tenant and to active to resume it",
+					"properties": Schema{
+						"name":   Schema{"type": "string"},
+						"status": Schema{"type": "string", "enum": []string{TenantActive, TenantSuspended}},
+						"quotas": ref("TenantQuotas"),
+					},
+				},
+				"Error": {
+					"type":        "string",
+					"description": "Plain text error message",
+				},
+			},
+			Responses: map[string]APIReply{
+				"BadRequest":           textError("The request was malformed or failed validation"),
+				"NotFound":             textError("User not found"),
+				"MethodNotAllowed":     textError("Method not allowed"),
+				"NotAcceptable":        textError("None of the formats in Accept or ?format= is available"),
+				"UnsupportedMediaType": textError("The request body Content-Type cannot be decoded"),
+				"TenantUnavailable":    textError("The tenant is unknown (404), suspended or over its user quota (403), or the token is invalid (401)"),
+				"Unauthorized":         textError("The admin bearer token is missing or wrong"),
+			},
+		},
+	}
+	addNegotiation(spec)
+	addIdempotency(spec, "/api/post", "/api/form", "/api/upload", "/api/v2/users")
+	addTenancy(spec)
+	addV1Aliases(spec)
+	return spec
+}
+
+// addTenancy documents tenant selection on every tenant-scoped operation
+func addTenancy(spec OpenAPI) {
+	for path, item := range spec.Paths {
+		scoped := true
+		for _, prefix := range tenantFreePrefixes {
+			if strings.HasPrefix(path, prefix) {
+				scoped = false
+			}
+		}
+		if !scoped {
+			continue
+		}
+		for _, op := range item {
+			op.Parameters = append(op.Parameters, tenantHeaderParameter)
+			op.Responses["403"] = replyRef("TenantUnavailable")
+		}
+	}
+}
+
+// addIdempotency documents the Idempotency-Key header on the POST operation of each path
+func addIdempotency(spec OpenAPI, paths ...string) {
+	for _, path := range paths {
+		op := spec.Paths[path]["post"]
+		op.Parameters = append(op.Parameters, idempotencyKeyParameter)
+		op.Responses["409"] = textError("A request with this Idempotency-Key is still being processed")
+		op.Responses["422"] = textError("The Idempotency-Key was already used for a different request")
+	}
+}
+
+// addV1Aliases marks the original user operations deprecated and documents
+// them again under /api/v1
+func addV1Aliases(spec OpenAPI) {
+	for _, route := range v1Routes() {
+		for path, item := range spec.Paths {
+			if specPattern(path) != route.Pattern {
+				continue
+			}
+			op := item[strings.ToLower(route.Method)]
+			if op == nil {
+				continue
+			}
+			op.Deprecated = true
+
+			alias := *op
+			alias.OperationID += "V1"
+			aliasPath := "/api/v1" + strings.TrimPrefix(path, "/api")
+			if spec.Paths[aliasPath] == nil {
+				spec.Paths[aliasPath] = PathItem{}
+			}
+			spec.Paths[aliasPath][strings.ToLower(route.Method)] = &alias
+		}
+	}
+}
+
+// addNegotiation documents the alternative formats of every operation that
+// answers with the Response envelope, and of every JSON request body
+func addNegotiation(spec OpenAPI) {
+	formatParam := Parameter{
+		Name: "format", In: "query", Description: "Response format, overrides Accept",
+		Schema: Schema{"type": "string", "enum": codecNames()},
+	}
+
+	for _, item := range spec.Paths {
+		for _, op := range item {
+			negotiable := false
+			for code, reply := range op.Responses {
+				media, ok := reply.Content["application/json"]
+				if !ok || !isEnvelope(media.Schema) {
+					continue
+				}
+				negotiable = true
+				for _, codec := range codecs[1:] {
+					schema := media.Schema
+					if codec.Name == "csv" {
+						schema = Schema{"type": "string"}
+					}
+					reply.Content[codec.MediaTypes[0]] = MediaType{Schema: schema}
+				}
+				op.Responses[code] = reply
+			}
+			if negotiable {
+				op.Parameters = append(op.Parameters, formatParam)
+				op.Responses["406"] = replyRef("NotAcceptable")
+			}
+
+			if op.RequestBody == nil {
+				continue
+			}
+			if media, ok := op.RequestBody.Content["application/json"]; ok {
+				content := map[string]MediaType{}
+				for mediaType, existing := range op.RequestBody.Content {
+					content[mediaType] = existing
+				}
+				for _, codec := range append(codecs[1:len(codecs):len(codecs)], formCodec) {
+					content[codec.MediaTypes[0]] = media
+				}
+				op.RequestBody = &RequestBody{Required: op.RequestBody.Required, Content: content}
+				op.Responses["415"] = replyRef("UnsupportedMediaType")
+			}
+		}
+	}
+}
+
+func isEnvelope(schema Schema) bool {
+	if schema["$ref"] == "#/components/schemas/Response" {
+		return true
+	}
+	parts, _ := schema["allOf"].([]Schema)
+	return len(parts) > 0 && parts[0]["$ref"] == "#/components/schemas/Response"
+}
+
+func codecNames() []string {
+	var names []string
+	for _, codec := range codecs {
+		names = append(names, codec.Name)
+	}
+	return names
+}
+
+// specPattern turns an OpenAPI path such as /api/put/{id} into the
+// ServeMux pattern that serves it, /api/put/
+func specPattern(path string) string {
+	if i := strings.Index(path, "{"); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// checkOpenAPIRoutes reports every route missing from the document and
+// every documented operation that has no registered route
+func checkOpenAPIRoutes(spec OpenAPI, routes []Route) error {
+	registered := map[string]bool{}
+	for _, route := range routes {
+		registered[route.Method+" "+route.Pattern] = true
+	}
+
+	documented := map[string]bool{}
+	for path, item := range spec.Paths {
+		for method := range item {
+			documented[strings.ToUpper(method)+" "+specPattern(path)] = true
+		}
+	}
+
+	var problems []string
+	for key := range registered {
+		if !documented[key] {
+			problems = append(problems, "undocumented route: "+key)
+		}
+	}
+	for key := range documented {
+		if !registered[key] {
+			problems = append(problems, "documented route is not registered: "+key)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(problems)
+	return fmt.Errorf("OpenAPI document is out of date:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// OpenAPI handler function
+func handleOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logEndpoint(r, startTime, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(buildOpenAPISpec())
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// docsOperation is one row of the HTML documentation page
+type docsOperation struct {
+	Method string
+	Path   string
+	*Operation
+	Replies []docsReply
+}
+
+type docsReply struct {
+	Code        string
+	Description string
+}
+
+// Docs handler function
+func handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logEndpoint(r, startTime, http.StatusMethodNotAllowed)
+		return
+	}
+
+	spec := buildOpenAPISpec()
+	specJSON, _ := json.MarshalIndent(spec, "", "  ")
+
+	var operations []docsOperation
+	for path, item := range spec.Paths {
+		for method, op := range item {
+			var replies []docsReply
+			for code, reply := range op.Responses {
+				if name, ok := strings.CutPrefix(reply.Ref, "#/components/responses/"); ok {
+					reply = spec.Components.Responses[name]
+				}
+				replies = append(replies, docsReply{Code: code, Description: reply.Description})
+			}
+			sort.Slice(replies, func(i, j int) bool { return replies[i].Code < replies[j].Code })
+			operations = append(operations, docsOperation{Method: strings.ToUpper(method), Path: path, Operation: op, Replies: replies})
+		}
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		if operations[i].Path != operations[j].Path {
+			return operations[i].Path < operations[j].Path
+		}
+		return operations[i].Method < operations[j].Method
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := docsTemplate.Execute(w, map[string]any{
+		"Info":       spec.Info,
+		"Operations": operations,
+		"Spec":       string(specJSON),
+	}); err != nil {
+		fmt.Printf("Error rendering docs: %s\n", err)
+	}
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// The docs page has no external assets so it works offline. The "Try it"
+// forms need JavaScript; everything else is plain HTML.
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Info.Title}} {{.Info.Version}}</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: 2em auto; color: #222; }
+details { border: 1px solid #ccc; border-radius: 4px; margin: .5em 0; padding: .5em; }
+summary { cursor: pointer; }
+.method { display: inline-block; width: 5em; font-weight: bold; }
+.GET { color: #2a7ae2; } .POST { color: #2a9d3f; } .PUT { color: #c07b00; } .DELETE { color: #c0392b; }
+code, pre { background: #f5f5f5; }
+pre { padding: .5em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Info.Title}} <small>{{.Info.Version}}</small></h1>
+<p>{{.Info.Description}} The raw document is at <a href="/openapi.json">/openapi.json</a>.</p>
+{{range .Operations}}
+<details>
+<summary><span class="method {{.Method}}">{{.Method}}</span> <code>{{.Path}}</code> {{.Summary}}</summary>
+{{if .Parameters}}<h4>Parameters</h4><ul>{{range .Parameters}}<li><code>{{.Name}}</code> ({{.In}}{{if .Required}}, required{{end}}) {{.Description}}</li>{{end}}</ul>{{end}}
+{{if .RequestBody}}<h4>Request body</h4><ul>{{range $type, $media := .RequestBody.Content}}<li><code>{{$type}}</code></li>{{end}}</ul>{{end}}
+<h4>Responses</h4>
+<ul>{{range .Replies}}<li><code>{{.Code}}</code> {{.Description}}</li>{{end}}</ul>
+<form class="try" data-method="{{.Method}}" data-path="{{.Path}}">
+{{range .Parameters}}<label>{{.Name}} <input name="{{.Name}}" data-in="{{.In}}"></label> {{end}}
+{{if .RequestBody}}<br><label>Content-Type <select name="content-type">{{range $type, $media := .RequestBody.Content}}<option>{{$type}}</option>{{end}}</select></label>
+<br><textarea name="body" rows="4" cols="60" placeholder="Request body; name=value&amp;... for form types"></textarea><br>{{end}}
+<button type="submit">Try it</button>
+<pre class="result" hidden></pre>
+</form>
+</details>
+{{end}}
+<details>
+<summary>OpenAPI document</summary>
+<pre>{{.Spec}}</pre>
+</details>
+<script>
+document.querySelectorAll("form.try").forEach(function (form) {
+  form.addEventListener("submit", async function (event) {
+    event.preventDefault();
+    var path = form.dataset.path, query = new URLSearchParams(), headers = new Headers();
+    form.querySelectorAll("input[data-in]").forEach(function (input) {
+      if (input.dataset.in === "path") path = path.replace("{" + input.name + "}", encodeURIComponent(input.value));
+      else if (!input.value) return;
+      else if (input.dataset.in === "header") headers.set(input.name, input.value);
+      else query.set(input.name, input.value);
+    });
+    var options = { method: form.dataset.method, headers: headers };
+    if (form.body && form.body.value) {
+      var type = form.elements["content-type"].value;
+      if (type.startsWith("multipart/")) {
+        // fetch sets the Content-Type itself, with the boundary
+        options.body = new FormData();
+        new URLSearchParams(form.body.value).forEach(function (value, name) { options.body.append(name, value); });
+      } else {
+        headers.set("Content-Type", type);
+        options.body = form.body.value;
+      }
+    }
+    var result = form.querySelector(".result");
+    result.hidden = false;
+    try {
+      var res = await fetch(path + (query.toString() ? "?" + query : ""), options);
+      result.textContent = res.status + " " + res.statusText + "\n\n" + await res.text();
+    } catch (err) {
+      result.textContent = String(err);
+    }
+  });
+});
+</script>
+</body>
+</html>
+`))
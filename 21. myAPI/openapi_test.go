@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	if err := checkOpenAPIRoutes(buildOpenAPISpec(), apiRoutes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckOpenAPIRoutesReportsDrift(t *testing.T) {
+	routes := append(apiRoutes(), Route{http.MethodGet, "/api/not-documented", nil})
+	err := checkOpenAPIRoutes(buildOpenAPISpec(), routes)
+	if err == nil || !strings.Contains(err.Error(), "undocumented route: GET /api/not-documented") {
+		t.Errorf("an undocumented route gave %v", err)
+	}
+
+	spec := buildOpenAPISpec()
+	spec.Paths["/api/not-registered"] = PathItem{"get": &Operation{Summary: "Nothing"}}
+	err = checkOpenAPIRoutes(spec, apiRoutes())
+	if err == nil || !strings.Contains(err.Error(), "documented route is not registered: GET /api/not-registered") {
+		t.Errorf("an unregistered path gave %v", err)
+	}
+}
+
+// TestOpenAPIReferencesResolve checks that every $ref in the document
+// points at something in it
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	data, err := json.Marshal(buildOpenAPISpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	var walk func(node any)
+	walk = func(node any) {
+		switch node := node.(type) {
+		case map[string]any:
+			if ref, ok := node["$ref"].(string); ok && !resolvesIn(doc, ref) {
+				t.Errorf("$ref %s does not resolve", ref)
+			}
+			for _, child := range node {
+				walk(child)
+			}
+		case []any:
+			for _, child := range node {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+}
+
+func resolvesIn(doc any, ref string) bool {
+	path, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return false
+	}
+	node := doc
+	for _, part := range strings.Split(path, "/") {
+		m, ok := node.(map[string]any)
+		if !ok {
+			return false
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		if node, ok = m[part]; !ok {
+			return false
+		}
+	}
+	return true
+}
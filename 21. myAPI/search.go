@@ -0,0 +1,364 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Search data structures
+type SearchHit struct {
+	User       User              `json:"user"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// Match scores: an exact term beats a prefix, which beats a typo
+const (
+	scoreExact  = 3.0
+	scorePrefix = 2.0
+	scoreFuzzy  = 1.0
+)
+
+// SearchIndex is an inverted index from terms in a user's name and email to
+// the users containing them. UserStore keeps it in step with every change.
+type SearchIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[int]bool
+	terms    []string // sorted keys of postings, rebuilt lazily
+	dirty    bool
+	docs     map[int]User
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		postings: map[string]map[int]bool{},
+		docs:     map[int]User{},
+	}
+}
+
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lower-case words with their byte offsets
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// userTerms lists every term indexed for a user; the whole email is a term too
+func userTerms(user User) []string {
+	var terms []string
+	for _, t := range tokenize(user.Name) {
+		terms = append(terms, t.term)
+	}
+	for _, t := range tokenize(user.Email) {
+		terms = append(terms, t.term)
+	}
+	if user.Email != "" {
+		terms = append(terms, strings.ToLower(user.Email))
+	}
+	return terms
+}
+
+// Add indexes a user, replacing any previous entry with the same ID
+func (idx *SearchIndex) Add(user User) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(user.ID)
+	idx.docs[user.ID] = user
+	for _, term := range userTerms(user) {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[int]bool{}
+			idx.dirty = true
+		}
+		idx.postings[term][user.ID] = true
+	}
+}
+
+func (idx *SearchIndex) Remove(id int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(id)
+}
+
+func (idx *SearchIndex) remove(id int) {
+	user, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	delete(idx.docs, id)
+	for _, term := range userTerms(user) {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+			idx.dirty = true
+		}
+	}
+}
+
+// sortedTerms returns the vocabulary in order, for prefix lookups
+func (idx *SearchIndex) sortedTerms() []string {
+	idx.mu.RLock()
+	if !idx.dirty {
+		defer idx.mu.RUnlock()
+		return idx.terms
+	}
+	idx.mu.RUnlock()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.dirty {
+		terms := make([]string, 0, len(idx.postings))
+		for term := range idx.postings {
+			terms = append(terms, term)
+		}
+		sort.Strings(terms)
+		idx.terms = terms
+		idx.dirty = false
+	}
+	return idx.terms
+}
+
+// maxEdits is how many typos a query word of this length may contain
+func maxEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n <= 3:
+		return 0
+	case n <= 7:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// matchTerms scores every indexed term that matches one query word
+func (idx *SearchIndex) matchTerms(word string) map[string]float64 {
+	terms := idx.sortedTerms()
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	matches := map[string]float64{}
+	if _, ok := idx.postings[word]; ok {
+		matches[word] = scoreExact
+	}
+
+	for i := sort.SearchStrings(terms, word); i < len(terms) && strings.HasPrefix(terms[i], word); i++ {
+		if _, ok := matches[terms[i]]; !ok {
+			matches[terms[i]] = scorePrefix
+		}
+	}
+
+	if limit := maxEdits(word); limit > 0 {
+		for _, term := range terms {
+			if _, ok := matches[term]; ok {
+				continue
+			}
+			if d := editDistance(word, term, limit); d <= limit {
+				matches[term] = scoreFuzzy / float64(d)
+			}
+		}
+	}
+	return matches
+}
+
+// Search returns users matching every word of the query, best first
+func (idx *SearchIndex) Search(query string, limit int) []SearchHit {
+	words := tokenize(query)
+	if len(words) == 0 {
+		return []SearchHit{}
+	}
+
+	scores := map[int]float64{}
+	matched := map[int]map[string]bool{}
+	for i, word := range words {
+		terms := idx.matchTerms(word.term)
+		wordScores := map[int]float64{}
+		idx.mu.RLock()
+		for term, score := range terms {
+			for id := range idx.postings[term] {
+				if score > wordScores[id] {
+					wordScores[id] = score
+				}
+				if matched[id] == nil {
+					matched[id] = map[string]bool{}
+				}
+				matched[id][term] = true
+			}
+		}
+		idx.mu.RUnlock()
+
+		// Keep only users that matched every earlier word as well
+		for id, score := range wordScores {
+			if i == 0 {
+				scores[id] = score
+			} else if _, ok := scores[id]; ok {
+				scores[id] += score
+			}
+		}
+		for id := range scores {
+			if _, ok := wordScores[id]; !ok {
+				delete(scores, id)
+			}
+		}
+	}
+
+	idx.mu.RLock()
+	hits := make([]SearchHit, 0, len(scores))
+	for id, score := range scores {
+		user := idx.docs[id]
+		hits = append(hits, SearchHit{
+			User:  user,
+			Score: score,
+			Highlights: map[string]string{
+				"name":  highlight(user.Name, matched[id]),
+				"email": highlight(user.Email, matched[id]),
+			},
+		})
+	}
+	idx.mu.RUnlock()
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].User.ID < hits[j].User.ID
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// highlight wraps matched words in <mark> and escapes everything else
+func highlight(text string, terms map[string]bool) string {
+	if terms[strings.ToLower(text)] {
+		return "<mark>" + html.EscapeString(text) + "</mark>"
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, t := range tokenize(text) {
+		if !terms[t.term] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[last:t.start]))
+		b.WriteString("<mark>" + html.EscapeString(text[t.start:t.end]) + "</mark>")
+		last = t.end
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+// editDistance is the Damerau-Levenshtein (optimal string alignment)
+// distance between a and b, giving up early once it exceeds limit
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > limit {
+		return limit + 1
+	}
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Search handler function
+func handleSearchUsers(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logEndpoint(r, startTime, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Query parameter q is required", http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxSearchLimit), http.StatusBadRequest)
+			logEndpoint(r, startTime, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	hits, err := storeFor(r).Search(r.Context(), query, limit)
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	sendResponse(w, r, Response{
+		Message: strconv.Itoa(len(hits)) + " users found",
+		Status:  http.StatusOK,
+		Data:    hits,
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
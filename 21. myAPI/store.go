@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User validation rules
+const maxNameLength = 100
+
+var (
+	errNameRequired  = errors.New("name is required")
+	errNameTooLong   = fmt.Errorf("name must be at most %d characters", maxNameLength)
+	errEmailRequired = errors.New("email is required")
+	errEmailInvalid  = errors.New("email is not a valid address")
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// A user is pending until they follow the link in their verification
+// email. Users saved before verification existed are active.
+const (
+	UserPending = "pending"
+	UserActive  = "active"
+)
+
+var (
+	errAlreadyVerified = errors.New("email address is already verified")
+	errEmailChanged    = errors.New("email address has changed since the link was sent")
+)
+
+// Validate checks a user against the rules shared by every endpoint
+func (u User) Validate() error {
+	return validateUser(u.Name, u.Email)
+}
+
+func validateUser(name, email string) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+	return validateEmail(email)
+}
+
+func validateName(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errNameRequired
+	}
+	if len([]rune(name)) > maxNameLength {
+		return errNameTooLong
+	}
+	return nil
+}
+
+func validateEmail(email string) error {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return errEmailRequired
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errEmailInvalid
+	}
+	return nil
+}
+
+// userRecord is how the store keeps a user. The v1 User and v2 UserV2
+// wire types are both views of it.
+type userRecord struct {
+	ID        int       `json:"id"`
+	PublicID  string    `json:"public_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Status     string     `json:"status"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
+
+// V1 is the legacy view with an integer ID and a preformatted timestamp
+func (u userRecord) V1() User {
+	return User{
+		ID:        u.ID,
+		Name:      u.Name,
+		Email:     u.Email,
+		CreatedAt: u.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newPublicID() string {
+	return "usr_" + randomHex(12)
+}
+
+// UserStore keeps users in memory, ordered by ID, and persists them to a file
+type UserStore struct {
+	mu       sync.RWMutex
+	saveMu   sync.Mutex // serializes Save, so saves never interleave
+	users    []userRecord
+	file     string
+	index    *SearchIndex
+	loaded   bool
+	maxUsers int // 0 means unlimited
+}
+
+// NewUserStore creates a store whose seed users are already verified
+func NewUserStore(file string, seed ...User) *UserStore {
+	s := &UserStore{file: file, index: NewSearchIndex()}
+	added, _ := s.Add(context.Background(), seed...)
+	for _, record := range added {
+		s.Verify(context.Background(), record.PublicID, record.Email)
+	}
+	return s
+}
+
+var store = NewUserStore("users.json",
+	User{Name: "John Doe", Email: "john@example.com"},
+)
+
+// Load replaces the users with those saved in the store file. The seed
+// users are kept when there is no file yet. Files written before v2 have
+// no public IDs or update times, and files written before email
+// verification no status; those are filled in.
+func (s *UserStore) Load() error {
+	migrated, err := s.load()
+	if err != nil || !migrated {
+		return err
+	}
+	// Keep the public IDs handed out above stable across restarts
+	return s.Save(context.Background())
+}
+
+func (s *UserStore) load() (migrated bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		s.loaded = true
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var users []userRecord
+	if err := json.Unmarshal(data, &users); err != nil {
+		return false, fmt.Errorf("reading %s: %w", s.file, err)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	index := NewSearchIndex()
+	for i := range users {
+		if users[i].PublicID == "" {
+			users[i].PublicID = newPublicID()
+			migrated = true
+		}
+		if users[i].UpdatedAt.IsZero() {
+			users[i].UpdatedAt = users[i].CreatedAt
+		}
+		if users[i].Status == "" {
+			users[i].Status = UserActive
+			migrated = true
+		}
+		index.Add(users[i].V1())
+	}
+	s.users = users
+	s.index = index
+	s.loaded = true
+	return migrated, nil
+}
+
+// Check reports whether the store has been loaded and its file can be written
+func (s *UserStore) Check(ctx context.Context) error {
+	s.mu.RLock()
+	loaded := s.loaded
+	s.mu.RUnlock()
+	if !loaded {
+		return errors.New("users have not been loaded")
+	}
+
+	probe, err := os.CreateTemp(filepath.Dir(s.file), ".users-probe-*")
+	if err != nil {
+		return fmt.Errorf("store directory is not writable: %w", err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// SetMaxUsers sets the quota Add enforces; 0 removes it
+func (s *UserStore) SetMaxUsers(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxUsers = n
+}
+
+// Every operation below checks its context first, so work for a request
+// that has been cancelled or has run out of time is not started.
+
+// List returns a copy of all users
+func (s *UserStore) List(ctx context.Context) ([]userRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]userRecord(nil), s.users...), nil
+}
+
+// Next returns the first user with an ID greater than afterID. Walking the
+// store with Next never holds the lock between users, so a slow reader
+// does not block writers.
+func (s *UserStore) Next(afterID int) (userRecord, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := sort.Search(len(s.users), func(i int) bool { return s.users[i].ID > afterID })
+	if i == len(s.users) {
+		return userRecord{}, false
+	}
+	return s.users[i], true
+}
+
+// Each calls fn for every user in ID order until fn returns an error or
+// ctx is done
+func (s *UserStore) Each(ctx context.Context, fn func(userRecord) error) error {
+	lastID := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		user, ok := s.Next(lastID)
+		if !ok {
+			return nil
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+		lastID = user.ID
+	}
+}
+
+// Get finds a user by its v1 numeric ID or its v2 public ID
+func (s *UserStore) Get(ctx context.Context, id string) (userRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return userRecord{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if i := s.indexOf(id); i >= 0 {
+		return s.users[i], nil
+	}
+	return userRecord{}, errUserNotFound
+}
+
+// Add stores the name and email of each user under a new ID, pending
+// verification of the email address. Either all
+// users are added or, when ctx is already done or the quota would be
+// exceeded, none are.
+func (s *UserStore) Add(ctx context.Context, newUsers ...User) ([]userRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxUsers > 0 && len(s.users)+len(newUsers) > s.maxUsers {
+		return nil, fmt.Errorf("%w: at most %d users are allowed", errUserQuotaExceeded, s.maxUsers)
+	}
+
+	added := make([]userRecord, 0, len(newUsers))
+	for _, user := range newUsers {
+		now := time.Now().UTC()
+		record := userRecord{
+			ID:        s.nextID(),
+			PublicID:  newPublicID(),
+			Name:      user.Name,
+			Email:     user.Email,
+			CreatedAt: now,
+			UpdatedAt: now,
+			Status:    UserPending,
+		}
+		s.users = append(s.users, record)
+		s.index.Add(record.V1())
+		added = append(added, record)
+	}
+	return added, nil
+}
+
+// Update replaces the name and email of a user, keeping its IDs and
+// creation time. A new email address has to be verified again.
+func (s *UserStore) Update(ctx context.Context, id string, changes User) (userRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return userRecord{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(id)
+	if i < 0 {
+		return userRecord{}, errUserNotFound
+	}
+	if s.users[i].Email != changes.Email {
+		s.users[i].Status = UserPending
+		s.users[i].VerifiedAt = nil
+	}
+	s.users[i].Name = changes.Name
+	s.users[i].Email = changes.Email
+	s.users[i].UpdatedAt = time.Now().UTC()
+	s.index.Add(s.users[i].V1())
+	return s.users[i], nil
+}
+
+// Verify activates a pending user, provided email is still its address
+func (s *UserStore) Verify(ctx context.Context, id, email string) (userRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return userRecord{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(id)
+	if i < 0 {
+		return userRecord{}, errUserNotFound
+	}
+	user := &s.users[i]
+	if user.Email != email {
+		return *user, errEmailChanged
+	}
+	if user.Status == UserActive {
+		return *user, errAlreadyVerified
+	}
+	now := time.Now().UTC()
+	user.Status = UserActive
+	user.VerifiedAt = &now
+	user.UpdatedAt = now
+	return *user, nil
+}
+
+// Delete removes a user and returns it
+func (s *UserStore) Delete(ctx context.Context, id string) (userRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return userRecord{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(id)
+	if i < 0 {
+		return userRecord{}, errUserNotFound
+	}
+	removed := s.users[i]
+	s.users = append(s.users[:i], s.users[i+1:]...)
+	s.index.Remove(removed.ID)
+	return removed, nil
+}
+
+// Search finds users by name and email, tolerating prefixes and typos
+func (s *UserStore) Search(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	index := s.index
+	s.mu.RUnlock()
+	return index.Search(query, limit), nil
+}
+
+// Save writes all users to the store file. The file is replaced
+// atomically, so a save abandoned because ctx is done leaves the previous
+// file in place. Saves run one at a time and each takes its snapshot once
+// the previous one is done, so an older snapshot can never replace a
+// newer one.
+func (s *UserStore) Save(ctx context.Context) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.RLock()
+	data, err := json.MarshalIndent(s.users, "", "    ")
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), filepath.Base(s.file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // a no-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// indexOf finds a user by either kind of ID; callers must hold s.mu
+func (s *UserStore) indexOf(id string) int {
+	for i, user := range s.users {
+		if fmt.Sprint(user.ID) == id || user.PublicID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *UserStore) nextID() int {
+	if len(s.users) == 0 {
+		return 1
+	}
+	return s.users[len(s.users)-1].ID + 1
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tenant statuses
+const (
+	TenantActive    = "active"
+	TenantSuspended = "suspended"
+)
+
+// defaultTenantID is used for requests that name no tenant. It keeps the
+// original users.json and uploads directory.
+const defaultTenantID = "default"
+
+// Tenancy settings, set from the command line
+var (
+	tenantDomain      string // base domain; acme.<tenantDomain> resolves to tenant acme
+	tenantTokenSecret string // HS256 secret for bearer tokens carrying a "tenant" claim
+	trustTenantHeader bool   // accept X-Tenant-ID and subdomains without a token, behind a trusted gateway or in development
+	adminToken        string // bearer token for /api/admin; the admin API is off without it
+)
+
+// registerTenantFlags adds the tenancy flags to fs
+func registerTenantFlags(fs *flag.FlagSet) {
+	fs.StringVar(&tenantDomain, "tenant-domain", "", "base domain whose subdomains name tenants, e.g. api.example.com")
+	fs.StringVar(&tenantTokenSecret, "tenant-token-secret", os.Getenv("MYAPI_TENANT_TOKEN_SECRET"), "HS256 secret of bearer tokens with a tenant claim (default $MYAPI_TENANT_TOKEN_SECRET)")
+	fs.BoolVar(&trustTenantHeader, "trust-tenant-header", false, "accept X-Tenant-ID and tenant subdomains without a bearer token; only behind a gateway that sets them, or in development")
+	fs.StringVar(&adminToken, "admin-token", os.Getenv("MYAPI_ADMIN_TOKEN"), "bearer token for the tenant admin API (default $MYAPI_ADMIN_TOKEN)")
+}
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+var (
+	errUserQuotaExceeded   = errors.New("user quota exceeded")
+	errUploadQuotaExceeded = errors.New("upload quota exceeded")
+)
+
+// TenantQuotas limit what a tenant may store; zero means unlimited
+type TenantQuotas struct {
+	MaxUsers       int   `json:"max_users"`
+	MaxUploadBytes int64 `json:"max_upload_bytes"`
+}
+
+// Tenant is one isolated customer with its own users, ID sequence and uploads
+type Tenant struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Status    string       `json:"status"`
+	Quotas    TenantQuotas `json:"quotas"`
+	CreatedAt string       `json:"created_at"`
+
+	store   *UserStore
+	uploads string
+}
+
+// TenantPatch is the body of an admin update; absent fields are left alone
+type TenantPatch struct {
+	Name   *string       `json:"name"`
+	Status *string       `json:"status"`
+	Quotas *TenantQuotas `json:"quotas"`
+}
+
+// TenantRegistry keeps the tenants, persisted to a file, and the data of
+// every tenant but the default one in a directory per tenant
+type TenantRegistry struct {
+	mu      sync.RWMutex
+	file    string
+	dir     string
+	tenants map[string]*Tenant
+}
+
+func NewTenantRegistry(file, dir string) *TenantRegistry {
+	return &TenantRegistry{
+		file: file,
+		dir:  dir,
+		tenants: map[string]*Tenant{
+			defaultTenantID: {
+				ID:        defaultTenantID,
+				Name:      "Default",
+				Status:    TenantActive,
+				CreatedAt: time.Now().Format(time.RFC3339),
+				store:     store,
+				uploads:   uploadsDir,
+			},
+		},
+	}
+}
+
+var tenants = NewTenantRegistry("tenants.json", "tenants")
+
+// Load reads the tenant list and loads every tenant's users
+func (t *TenantRegistry) Load() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := os.ReadFile(t.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []*Tenant
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("reading %s: %w", t.file, err)
+	}
+
+	for _, tenant := range saved {
+		if tenant.ID == defaultTenantID {
+			tenant.store = store
+			tenant.uploads = uploadsDir
+		} else {
+			tenant.store = NewUserStore(filepath.Join(t.dir, tenant.ID, "users.json"))
+			tenant.uploads = filepath.Join(t.dir, tenant.ID, "uploads")
+			if err := tenant.store.Load(); err != nil {
+				return fmt.Errorf("tenant %s: %w", tenant.ID, err)
+			}
+		}
+		tenant.store.SetMaxUsers(tenant.Quotas.MaxUsers)
+		t.tenants[tenant.ID] = tenant
+	}
+	return nil
+}
+
+// save writes the tenant list atomically; callers must hold t.mu
+func (t *TenantRegistry) save() error {
+	list := make([]*Tenant, 0, len(t.tenants))
+	for _, tenant := range t.tenants {
+		list = append(list, tenant)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := t.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.file)
+}
+
+// Get returns a copy of a tenant
+func (t *TenantRegistry) Get(id string) (Tenant, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	tenant, ok := t.tenants[id]
+	if !ok {
+		return Tenant{}, false
+	}
+	return *tenant, true
+}
+
+// List returns copies of all tenants, ordered by ID
+func (t *TenantRegistry) List() []Tenant {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	list := make([]Tenant, 0, len(t.tenants))
+	for _, tenant := range t.tenants {
+		list = append(list, *tenant)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Create adds an active tenant with an empty user store and upload directory
+func (t *TenantRegistry) Create(tenant Tenant) (Tenant, error) {
+	if !tenantIDPattern.MatchString(tenant.ID) {
+		return tenant, errors.New("id must be 2 to 63 lower-case letters, digits or dashes, starting with a letter or digit")
+	}
+	if tenant.Quotas.MaxUsers < 0 || tenant.Quotas.MaxUploadBytes < 0 {
+		return tenant, errors.New("quotas must not be negative")
+	}
+	if tenant.Name == "" {
+		tenant.Name = tenant.ID
+	}
+	tenant.Status = TenantActive
+	tenant.CreatedAt = time.Now().Format(time.RFC3339)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.tenants[tenant.ID]; exists {
+		return tenant, fmt.Errorf("tenant %s already exists", tenant.ID)
+	}
+
+	tenant.uploads = filepath.Join(t.dir, tenant.ID, "uploads")
+	if err := os.MkdirAll(tenant.uploads, os.ModePerm); err != nil {
+		return tenant, err
+	}
+	tenant.store = NewUserStore(filepath.Join(t.dir, tenant.ID, "users.json"))
+	if err := tenant.store.Load(); err != nil {
+		return tenant, err
+	}
+	tenant.store.SetMaxUsers(tenant.Quotas.MaxUsers)
+
+	t.tenants[tenant.ID] = &tenant
+	return tenant, t.save()
+}
+
+// Update applies an admin patch, which is how tenants are suspended and resumed
+func (t *TenantRegistry) Update(id string, patch TenantPatch) (Tenant, error) {
+	if patch.Status != nil && *patch.Status != TenantActive && *patch.Status != TenantSuspended {
+		return Tenant{}, fmt.Errorf("status must be %s or %s", TenantActive, TenantSuspended)
+	}
+	if patch.Quotas != nil && (patch.Quotas.MaxUsers < 0 || patch.Quotas.MaxUploadBytes < 0) {
+		return Tenant{}, errors.New("quotas must not be negative")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tenant, ok := t.tenants[id]
+	if !ok {
+		return Tenant{}, errTenantNotFound
+	}
+	if patch.Name != nil {
+		tenant.Name = *patch.Name
+	}
+	if patch.Status != nil {
+		tenant.Status = *patch.Status
+	}
+	if patch.Quotas != nil {
+		tenant.Quotas = *patch.Quotas
+		tenant.store.SetMaxUsers(tenant.Quotas.MaxUsers)
+	}
+	return *tenant, t.save()
+}
+
+var errTenantNotFound = errors.New("tenant not found")
+
+// Delete removes a tenant together with its users and uploads
+func (t *TenantRegistry) Delete(id string) error {
+	if id == defaultTenantID {
+		return errors.New("the default tenant cannot be deleted")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.tenants[id]; !ok {
+		return errTenantNotFound
+	}
+	delete(t.tenants, id)
+	if err := t.save(); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(t.dir, id))
+}
+
+// CheckStores is the readiness check for the user store of every tenant
+func (t *TenantRegistry) CheckStores(ctx context.Context) error {
+	for _, tenant := range t.List() {
+		if err := tenant.store.Check(ctx); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant.ID, err)
+		}
+	}
+	return nil
+}
+
+// uploadUsage is the total size of the tenant's uploads, leaving out the
+// file called skip, which an upload of the same name would replace
+func (t Tenant) uploadUsage(skip string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(t.uploads, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() || d.Name() == skip {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// checkUploadQuota fails if storing size more bytes as name would exceed the quota
+func (t Tenant) checkUploadQuota(name string, size int64) error {
+	if t.Quotas.MaxUploadBytes == 0 {
+		return nil
+	}
+	used, err := t.uploadUsage(name)
+	if err != nil {
+		return err
+	}
+	if used+size > t.Quotas.MaxUploadBytes {
+		return fmt.Errorf("%w: %d of %d bytes used", errUploadQuotaExceeded, used, t.Quotas.MaxUploadBytes)
+	}
+	return nil
+}
+
+type tenantKey struct{}
+
+// tenantFrom returns the tenant a request was resolved to
+func tenantFrom(ctx context.Context) Tenant {
+	if tenant, ok := ctx.Value(tenantKey{}).(Tenant); ok {
+		return tenant
+	}
+	tenant, _ := tenants.Get(defaultTenantID)
+	return tenant
+}
+
+// storeFor returns the user store of the request's tenant
+func storeFor(r *http.Request) *UserStore {
+	return tenantFrom(r.Context()).store
+}
+
+// Paths that are not scoped to a tenant
+var tenantFreePrefixes = []string{"/api/admin/", "/api/verify", "/healthz", "/readyz", "/openapi.json", "/docs"}
+
+// withTenant resolves the tenant of each request from a signed bearer
+// token, the X-Tenant-ID header or the subdomain, in that order, and
+// refuses requests for unknown or suspended tenants. The header and the
+// subdomain are chosen by the client, so on their own they only count
+// with -trust-tenant-header.
+func withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range tenantFreePrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		startTime := time.Now()
+		id, status, err := resolveTenantID(r)
+		if err != nil {
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="myapi"`)
+			}
+			http.Error(w, err.Error(), status)
+			logEndpoint(r, startTime, status)
+			return
+		}
+		tenant, ok := tenants.Get(id)
+		if !ok {
+			http.Error(w, "Unknown tenant "+id, http.StatusNotFound)
+			logEndpoint(r, startTime, http.StatusNotFound)
+			return
+		}
+		if tenant.Status != TenantActive {
+			http.Error(w, "Tenant "+id+" is suspended", http.StatusForbidden)
+			logEndpoint(r, startTime, http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("X-Tenant-ID", tenant.ID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+	})
+}
+
+func resolveTenantID(r *http.Request) (string, int, error) {
+	header := strings.TrimSpace(r.Header.Get("X-Tenant-ID"))
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && tenantTokenSecret != "" {
+		claim, err := tenantClaim(strings.TrimSpace(token), tenantTokenSecret)
+		if err != nil {
+			return "", http.StatusUnauthorized, err
+		}
+		if header != "" && header != claim {
+			return "", http.StatusForbidden, errors.New("X-Tenant-ID does not match the tenant of the token")
+		}
+		return claim, 0, nil
+	}
+	named := header
+	if named == "" {
+		named = tenantSubdomain(r.Host)
+	}
+	switch {
+	case named == "":
+		return defaultTenantID, 0, nil
+	case trustTenantHeader:
+		return named, 0, nil
+	case tenantTokenSecret != "":
+		return "", http.StatusUnauthorized, errors.New("a bearer token with a tenant claim is required to act for tenant " + named)
+	default:
+		return "", http.StatusForbidden, errors.New("tenants can only be chosen with a bearer token (-tenant-token-secret), or by header and subdomain with -trust-tenant-header")
+	}
+}
+
+// tenantSubdomain returns acme for a host acme.<tenantDomain>
+func tenantSubdomain(host string) string {
+	if tenantDomain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(tenantDomain))
+	if !ok || strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+// tenantClaim verifies an HS256 JWT and returns its "tenant" claim
+func tenantClaim(token, secret string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("bearer token is not a JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return "", errors.New("bearer token must be signed with HS256")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", errors.New("bearer token signature is invalid")
+	}
+
+	var claims struct {
+		Tenant string `json:"tenant"`
+		Exp    int64  `json:"exp"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", errors.New("bearer token claims are not valid JSON")
+	}
+	if claims.Exp != 0 && time.Now().Unix() >= claims.Exp {
+		return "", errors.New("bearer token has expired")
+	}
+	if claims.Tenant == "" {
+		return "", errors.New("bearer token has no tenant claim")
+	}
+	return claims.Tenant, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// adminOnly lets a request through only with the admin bearer token
+func adminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.Error(w, "The admin API is disabled, start the server with -admin-token", http.StatusForbidden)
+			logEndpoint(r, time.Now(), http.StatusForbidden)
+			return
+		}
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="myapi-admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			logEndpoint(r, time.Now(), http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Tenant list handler function
+func handleListTenants(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	sendResponse(w, r, Response{
+		Message: "Tenants retrieved successfully",
+		Status:  http.StatusOK,
+		Data:    tenants.List(),
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// Tenant create handler function
+func handleCreateTenant(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	var input Tenant
+	if err := decodeBody(r, &input); err != nil {
+		http.Error(w, err.Error(), decodeStatus(err))
+		logEndpoint(r, startTime, decodeStatus(err))
+		return
+	}
+
+	tenant, err := tenants.Create(input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Location", "/api/admin/tenants/"+tenant.ID)
+	sendResponse(w, r, Response{
+		Message: "Tenant created successfully",
+		Status:  http.StatusCreated,
+		Data:    tenant,
+	})
+	logEndpoint(r, startTime, http.StatusCreated)
+}
+
+// Tenant update handler function
+func handleUpdateTenant(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	id := getUserIDFromURL(r.URL.Path, "/api/admin/tenants/")
+	var patch TenantPatch
+	if err := decodeBody(r, &patch); err != nil {
+		http.Error(w, err.Error(), decodeStatus(err))
+		logEndpoint(r, startTime, decodeStatus(err))
+		return
+	}
+
+	tenant, err := tenants.Update(id, patch)
+	if errors.Is(err, errTenantNotFound) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+
+	sendResponse(w, r, Response{
+		Message: "Tenant updated successfully",
+		Status:  http.StatusOK,
+		Data:    tenant,
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// Tenant delete handler function
+func handleDeleteTenant(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	id := getUserIDFromURL(r.URL.Path, "/api/admin/tenants/")
+	err := tenants.Delete(id)
+	if errors.Is(err, errTenantNotFound) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+	if err := webhooks.UnsubscribeTenant(id); err != nil {
+		fmt.Printf("Error removing webhooks of tenant %s: %s\n", id, err)
+	}
+
+	sendResponse(w, r, Response{
+		Message: "Tenant deleted successfully",
+		Status:  http.StatusOK,
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tenantSettings are the tenancy flags a test runs with
+type tenantSettings struct {
+	secret string
+	trust  bool
+	domain string
+}
+
+// useTenants gives a test a registry with the tenants acme and globex, and
+// the given tenancy flags
+func useTenants(t *testing.T, settings tenantSettings) {
+	t.Helper()
+	dir := t.TempDir()
+	savedTenants := tenants
+	savedSecret, savedTrust, savedDomain := tenantTokenSecret, trustTenantHeader, tenantDomain
+	t.Cleanup(func() {
+		tenants = savedTenants
+		tenantTokenSecret, trustTenantHeader, tenantDomain = savedSecret, savedTrust, savedDomain
+	})
+
+	tenants = NewTenantRegistry(filepath.Join(dir, "tenants.json"), dir)
+	for _, id := range []string{"acme", "globex"} {
+		if _, err := tenants.Create(Tenant{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tenantTokenSecret, trustTenantHeader, tenantDomain = settings.secret, settings.trust, settings.domain
+}
+
+// signTenantToken makes an HS256 JWT with the given claims
+func signTenantToken(secret string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestTenantResolution(t *testing.T) {
+	const secret = "tenant-secret"
+	acmeToken := signTenantToken(secret, map[string]any{"tenant": "acme"})
+	expired := signTenantToken(secret, map[string]any{"tenant": "acme", "exp": time.Now().Add(-time.Minute).Unix()})
+	forged := signTenantToken("guessed", map[string]any{"tenant": "acme"})
+	noClaim := signTenantToken(secret, map[string]any{"sub": "someone"})
+	unknown := signTenantToken(secret, map[string]any{"tenant": "initech"})
+
+	tokens := tenantSettings{secret: secret, domain: "api.example.com"}
+	trusted := tenantSettings{secret: secret, trust: true, domain: "api.example.com"}
+	neither := tenantSettings{domain: "api.example.com"}
+
+	tests := []struct {
+		name     string
+		settings tenantSettings
+		token    string
+		header   string
+		host     string
+		status   int
+		tenant   string
+	}{
+		{"nothing names a tenant", tokens, "", "", "", http.StatusOK, defaultTenantID},
+		{"token", tokens, acmeToken, "", "", http.StatusOK, "acme"},
+		{"token and matching header", tokens, acmeToken, "acme", "", http.StatusOK, "acme"},
+		{"token and other header", tokens, acmeToken, "globex", "", http.StatusForbidden, ""},
+		{"token wins over the subdomain", trusted, acmeToken, "", "globex.api.example.com", http.StatusOK, "acme"},
+		{"token wins over a trusted header", trusted, acmeToken, "globex", "", http.StatusForbidden, ""},
+		{"forged token", tokens, forged, "", "", http.StatusUnauthorized, ""},
+		{"expired token", tokens, expired, "", "", http.StatusUnauthorized, ""},
+		{"token without a tenant", tokens, noClaim, "", "", http.StatusUnauthorized, ""},
+		{"token for an unknown tenant", tokens, unknown, "", "", http.StatusNotFound, ""},
+		{"header without a token", tokens, "", "globex", "", http.StatusUnauthorized, ""},
+		{"subdomain without a token", tokens, "", "", "globex.api.example.com", http.StatusUnauthorized, ""},
+		{"trusted header", trusted, "", "globex", "", http.StatusOK, "globex"},
+		{"trusted header wins over the subdomain", trusted, "", "acme", "globex.api.example.com", http.StatusOK, "acme"},
+		{"trusted subdomain", trusted, "", "", "globex.api.example.com:8080", http.StatusOK, "globex"},
+		{"header with no way to check it", neither, "", "globex", "", http.StatusForbidden, ""},
+		{"token with no secret to check it", neither, acmeToken, "", "", http.StatusOK, defaultTenantID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTenants(t, tt.settings)
+			handler := withTenant(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, tenantFrom(r.Context()).ID)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Tenant-ID", tt.header)
+			}
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != tt.tenant {
+				t.Errorf("tenant %q, want %q", w.Body, tt.tenant)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestSuspendedTenantIsRefused(t *testing.T) {
+	useTenants(t, tenantSettings{trust: true})
+	suspended := TenantSuspended
+	if _, err := tenants.Update("globex", TenantPatch{Status: &suspended}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	req.Header.Set("X-Tenant-ID", "globex")
+	w := httptest.NewRecorder()
+	withTenant(http.NotFoundHandler()).ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("suspended tenant got %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ServerConfig holds the listener settings read from the command line
+type ServerConfig struct {
+	Addr         string
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // enables mutual TLS when set
+	RedirectAddr string // plain HTTP listener that redirects to HTTPS
+}
+
+// registerFlags adds the listener and TLS flags to fs
+func (cfg *ServerConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Addr, "addr", ":8080", "address to listen on")
+	fs.StringVar(&cfg.CertFile, "tls-cert", "", "TLS certificate file (PEM); enables HTTPS and HTTP/2")
+	fs.StringVar(&cfg.KeyFile, "tls-key", "", "TLS private key file (PEM)")
+	fs.StringVar(&cfg.ClientCAFile, "tls-client-ca", "", "CA file (PEM) for verifying client certificates; enables mutual TLS")
+	fs.StringVar(&cfg.RedirectAddr, "redirect-addr", "", "address for a plain HTTP listener that redirects to HTTPS")
+}
+
+// check reports TLS options given without the ones they need
+func (cfg ServerConfig) check() error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if cfg.CertFile == "" && (cfg.ClientCAFile != "" || cfg.RedirectAddr != "") {
+		return errors.New("-tls-client-ca and -redirect-addr need -tls-cert and -tls-key")
+	}
+	return nil
+}
+
+// newTLSConfig builds a TLS 1.2+ configuration that offers HTTP/2
+func newTLSConfig(cfg ServerConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	if cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// serve runs the API over HTTP, or over HTTPS with an optional redirect
+// listener, until SIGINT or SIGTERM. It then stops accepting connections
+// and waits up to 10 seconds for open requests.
+func serve(cfg ServerConfig, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	servers := []*http.Server{server}
+	errs := make(chan error, 2)
+
+	if cfg.CertFile == "" {
+		go func() {
+			fmt.Printf("Server starting on %s...\n", cfg.Addr)
+			errs <- server.ListenAndServe()
+		}()
+	} else {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = tlsConfig
+
+		if cfg.RedirectAddr != "" {
+			redirect := &http.Server{
+				Addr:              cfg.RedirectAddr,
+				Handler:           httpsRedirect(cfg.Addr),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			servers = append(servers, redirect)
+			go func() {
+				fmt.Printf("Redirecting HTTP on %s to HTTPS...\n", cfg.RedirectAddr)
+				errs <- redirect.ListenAndServe()
+			}()
+		}
+		go func() {
+			mode := "TLS"
+			if cfg.ClientCAFile != "" {
+				mode = "mutual TLS"
+			}
+			fmt.Printf("Server starting on %s with %s and HTTP/2...\n", cfg.Addr, mode)
+			errs <- server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var shutdownErr error
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			shutdownErr = err
+		}
+	}
+	return shutdownErr
+}
+
+// httpsRedirect sends every request to the same path on the HTTPS listener
+func httpsRedirect(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		// 308 keeps the method and body, unlike 301
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+// runGenCert writes a development CA plus a server and a client certificate signed by it
+func runGenCert(args []string) error {
+	fs := flag.NewFlagSet("gen-cert", flag.ContinueOnError)
+	dir := fs.String("dir", "certs", "directory to write the PEM files to")
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "comma-separated DNS names and IPs for the server certificate")
+	days := fs.Int("days", 365, "validity period in days")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		return err
+	}
+
+	validFor := time.Duration(*days) * 24 * time.Hour
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	caTemplate := certTemplate("myAPI Development CA", validFor)
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return err
+	}
+
+	serverTemplate := certTemplate("myAPI Server", validFor)
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range strings.Split(*hosts, ",") {
+		host = strings.TrimSpace(host)
+		if ip := net.ParseIP(host); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else if host != "" {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		}
+	}
+
+	clientTemplate := certTemplate("myAPI Client", validFor)
+	clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	if err := writePEM(filepath.Join(*dir, "ca.pem"), "CERTIFICATE", caDER, 0644); err != nil {
+		return err
+	}
+	if err := writeKey(filepath.Join(*dir, "ca-key.pem"), caKey); err != nil {
+		return err
+	}
+	for name, template := range map[string]*x509.Certificate{"server": serverTemplate, "client": clientTemplate} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			return err
+		}
+		if err := writePEM(filepath.Join(*dir, name+".pem"), "CERTIFICATE", der, 0644); err != nil {
+			return err
+		}
+		if err := writeKey(filepath.Join(*dir, name+"-key.pem"), key); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Wrote ca.pem, server.pem and client.pem with their keys to %s\n", *dir)
+	fmt.Printf("Run: myapi -addr :8443 -tls-cert %s -tls-key %s\n",
+		filepath.Join(*dir, "server.pem"), filepath.Join(*dir, "server-key.pem"))
+	return nil
+}
+
+func certTemplate(commonName string, validFor time.Duration) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"myAPI development"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+}
+
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(path, "PRIVATE KEY", der, 0600)
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Import/export data structures
+type RejectedRow struct {
+	Line   int      `json:"line"`
+	Error  string   `json:"error"`
+	Record []string `json:"record"`
+}
+
+type ImportReport struct {
+	Imported int           `json:"imported"`
+	Rejected []RejectedRow `json:"rejected"`
+}
+
+const (
+	maxImportSize = 10 << 20
+	flushEvery    = 100
+)
+
+var csvHeader = []string{"id", "name", "email", "created_at"}
+
+// Header names accepted for each user field when no explicit mapping is given
+var columnAliases = map[string][]string{
+	"name":  {"name", "full_name", "fullname", "full name"},
+	"email": {"email", "e-mail", "mail", "email_address", "email address"},
+}
+
+// Export handler function
+func handleExportUsers(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logEndpoint(r, startTime, http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	var export func(context.Context, io.Writer, func()) error
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		export = exportUsersCSV
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		export = exportUsersNDJSON
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		export = exportUsersJSON
+	default:
+		http.Error(w, fmt.Sprintf("Unsupported format %q, use csv, ndjson or json", format), http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=users.%s", format))
+
+	flush := func() {}
+	if flusher, ok := w.(http.Flusher); ok {
+		flush = flusher.Flush
+	}
+
+	// Headers are already sent, so a failure here can only be logged
+	if err := export(r.Context(), w, flush); err != nil {
+		if ctxErr := r.Context().Err(); ctxErr != nil {
+			logCancelled(r, startTime, http.StatusOK, "export stopped: "+ctxErr.Error())
+			return
+		}
+		fmt.Printf("Error exporting users: %s\n", err)
+	}
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+func exportUsersCSV(ctx context.Context, w io.Writer, flush func()) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	count := 0
+	err := tenantFrom(ctx).store.Each(ctx, func(record userRecord) error {
+		user := record.V1()
+		if err := cw.Write([]string{strconv.Itoa(user.ID), user.Name, user.Email, user.CreatedAt}); err != nil {
+			return err
+		}
+		if count++; count%flushEvery == 0 {
+			cw.Flush()
+			flush()
+		}
+		return cw.Error()
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func exportUsersNDJSON(ctx context.Context, w io.Writer, flush func()) error {
+	enc := json.NewEncoder(w)
+
+	count := 0
+	return tenantFrom(ctx).store.Each(ctx, func(record userRecord) error {
+		user := record.V1()
+		if err := enc.Encode(user); err != nil {
+			return err
+		}
+		if count++; count%flushEvery == 0 {
+			flush()
+		}
+		return nil
+	})
+}
+
+func exportUsersJSON(ctx context.Context, w io.Writer, flush func()) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	count := 0
+	err := tenantFrom(ctx).store.Each(ctx, func(record userRecord) error {
+		user := record.V1()
+		data, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if count++; count%flushEvery == 0 {
+			flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+// Import handler function
+func handleImportUsers(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logEndpoint(r, startTime, http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			if requestAborted(w, r, startTime, err) {
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			logEndpoint(r, startTime, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	mapping, err := parseColumnMapping(r.URL.Query().Get("columns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+
+	valid, report, err := readUsersCSV(contextReader{r.Context(), body}, mapping)
+	if err != nil {
+		if requestAborted(w, r, startTime, err) {
+			return
+		}
+		http.Error(w, err.Error(), decodeStatus(err))
+		logEndpoint(r, startTime, decodeStatus(err))
+		return
+	}
+
+	if len(valid) > 0 {
+		added, err := storeFor(r).Add(r.Context(), valid...)
+		if requestAborted(w, r, startTime, err) {
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			logEndpoint(r, startTime, http.StatusForbidden)
+			return
+		}
+		for _, record := range added {
+			requestVerification(r, record)
+			publishEvent(r.Context(), EventUserCreated, record.V1())
+		}
+		saveUsersToFile(r.Context())
+	}
+	report.Imported = len(valid)
+
+	sendResponse(w, r, Response{
+		Message: fmt.Sprintf("Imported %d users, rejected %d rows", report.Imported, len(report.Rejected)),
+		Status:  http.StatusOK,
+		Data:    report,
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// parseColumnMapping reads an explicit mapping such as "name:Full Name,email:Mail"
+func parseColumnMapping(raw string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if raw == "" {
+		return mapping, nil
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		field, column, ok := strings.Cut(pair, ":")
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !ok || strings.TrimSpace(column) == "" {
+			return nil, fmt.Errorf("invalid column mapping %q, expected field:column", pair)
+		}
+		if _, known := columnAliases[field]; !known {
+			return nil, fmt.Errorf("unknown user field %q in column mapping", field)
+		}
+		mapping[field] = strings.TrimSpace(column)
+	}
+	return mapping, nil
+}
+
+// resolveColumns finds the index of each user field in the CSV header
+func resolveColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	index := map[string]int{}
+	for i, column := range header {
+		index[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+
+	columns := map[string]int{}
+	for field, aliases := range columnAliases {
+		if column, ok := mapping[field]; ok {
+			aliases = []string{column}
+		}
+		for _, alias := range aliases {
+			if i, ok := index[strings.ToLower(alias)]; ok {
+				columns[field] = i
+				break
+			}
+		}
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("CSV header has no column for %q", field)
+		}
+	}
+	return columns, nil
+}
+
+// readUsersCSV parses and validates CSV rows, collecting rejected rows with their line numbers
+func readUsersCSV(body io.Reader, mapping map[string]string) ([]User, ImportReport, error) {
+	report := ImportReport{Rejected: []RejectedRow{}}
+
+	cr := csv.NewReader(body)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, report, errors.New("CSV body is empty")
+	}
+	if err != nil {
+		return nil, report, err
+	}
+
+	columns, err := resolveColumns(header, mapping)
+	if err != nil {
+		return nil, report, err
+	}
+
+	var valid []User
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+				report.Rejected = append(report.Rejected, RejectedRow{Line: parseErr.StartLine, Error: "wrong number of fields", Record: record})
+				continue
+			}
+			return nil, report, err
+		}
+		line, _ := cr.FieldPos(0)
+
+		user := User{
+			Name:  strings.TrimSpace(record[columns["name"]]),
+			Email: strings.TrimSpace(record[columns["email"]]),
+		}
+		if err := user.Validate(); err != nil {
+			report.Rejected = append(report.Rejected, RejectedRow{Line: line, Error: err.Error(), Record: record})
+			continue
+		}
+		valid = append(valid, user)
+	}
+	return valid, report, nil
+}
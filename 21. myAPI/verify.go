@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Verification links carry an HS256 token naming the tenant, the user and
+// the email address being verified. A token stops working when it expires,
+// once it has been used, or when the user's address changes.
+var (
+	verifySecret  string
+	verifyTTL     = 24 * time.Hour
+	publicBaseURL string // e.g. https://api.example.com; no mail is sent without it
+)
+
+// registerVerifyFlags adds the verification link flags to fs
+func registerVerifyFlags(fs *flag.FlagSet) {
+	fs.StringVar(&verifySecret, "verify-secret", os.Getenv("MYAPI_VERIFY_SECRET"), "HS256 secret of email verification links (default $MYAPI_VERIFY_SECRET)")
+	fs.DurationVar(&verifyTTL, "verify-ttl", verifyTTL, "how long email verification links stay valid")
+	fs.StringVar(&publicBaseURL, "public-url", "", "base URL of emailed links, e.g. https://api.example.com; verification mail is only sent when it is set")
+}
+
+// checkPublicURL reports a -public-url that cannot be used in links
+func checkPublicURL() error {
+	if publicBaseURL == "" {
+		return nil
+	}
+	u, err := url.Parse(publicBaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("-public-url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+var (
+	errVerifyTokenInvalid = errors.New("verification link is invalid")
+	errVerifyTokenExpired = errors.New("verification link has expired")
+)
+
+type verifyClaims struct {
+	Tenant string `json:"tenant"`
+	User   string `json:"sub"`
+	Email  string `json:"email"`
+	Exp    int64  `json:"exp"`
+}
+
+func signVerifyToken(claims verifyClaims) string {
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, []byte(verifySecret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseVerifyToken checks the signature of token. The claims of an expired
+// token are returned along with errVerifyTokenExpired.
+func parseVerifyToken(token string) (verifyClaims, error) {
+	var claims verifyClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errVerifyTokenInvalid
+	}
+
+	mac := hmac.New(sha256.New, []byte(verifySecret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return claims, errVerifyTokenInvalid
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil || claims.Tenant == "" || claims.User == "" {
+		return claims, errVerifyTokenInvalid
+	}
+	if time.Now().Unix() >= claims.Exp {
+		return claims, errVerifyTokenExpired
+	}
+	return claims, nil
+}
+
+// requestVerification emails a pending user a link to /api/verify. The
+// mail is sent in the background so a slow mail server does not hold up
+// the request; failures are logged. The link is only ever built from
+// -public-url: the request's Host is chosen by the client, and a forged
+// one would send the victim's token to the forger's site.
+func requestVerification(r *http.Request, record userRecord) {
+	if record.Status != UserPending {
+		return
+	}
+	if publicBaseURL == "" {
+		fmt.Printf("Not sending a verification email to %s: start the server with -public-url\n", record.Email)
+		return
+	}
+	token := signVerifyToken(verifyClaims{
+		Tenant: tenantFrom(r.Context()).ID,
+		User:   record.PublicID,
+		Email:  record.Email,
+		Exp:    time.Now().Add(verifyTTL).Unix(),
+	})
+
+	msg := MailMessage{
+		To:      record.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nOpen this link to verify your email address:\n\n%s/api/verify?token=%s\n\nThe link expires in %s.\n",
+			record.Name, strings.TrimSuffix(publicBaseURL, "/"), url.QueryEscape(token), verifyTTL),
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := mailer.Send(ctx, msg); err != nil {
+			fmt.Printf("Error sending verification email to %s: %s\n", msg.To, err)
+		}
+	}()
+}
+
+// Verify handler function
+func handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	claims, err := parseVerifyToken(r.URL.Query().Get("token"))
+	if err != nil && !errors.Is(err, errVerifyTokenExpired) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+	expired := err != nil
+
+	// The link is opened from an email, so the tenant comes from the token
+	tenant, ok := tenants.Get(claims.Tenant)
+	if !ok {
+		http.Error(w, errTenantNotFound.Error(), http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+	if tenant.Status == TenantSuspended {
+		http.Error(w, "Tenant is suspended", http.StatusForbidden)
+		logEndpoint(r, startTime, http.StatusForbidden)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant))
+
+	record, err := storeFor(r).Get(r.Context(), claims.User)
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+
+	if expired && record.Status == UserPending && record.Email == claims.Email {
+		requestVerification(r, record)
+		http.Error(w, errVerifyTokenExpired.Error()+"; a new one has been sent", http.StatusGone)
+		logEndpoint(r, startTime, http.StatusGone)
+		return
+	}
+
+	record, err = storeFor(r).Verify(r.Context(), claims.User, claims.Email)
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, errAlreadyVerified):
+		http.Error(w, err.Error(), http.StatusConflict)
+		logEndpoint(r, startTime, http.StatusConflict)
+		return
+	case errors.Is(err, errEmailChanged):
+		http.Error(w, err.Error(), http.StatusGone)
+		logEndpoint(r, startTime, http.StatusGone)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+	saveUsersToFile(r.Context())
+	publishEvent(r.Context(), EventUserUpdated, record.V1())
+
+	sendResponse(w, r, Response{
+		Message: "Email address verified",
+		Status:  http.StatusOK,
+		Data:    record.V2(),
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
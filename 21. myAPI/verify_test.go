@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mailbox is a Mailer that hands every message to the test
+type mailbox chan MailMessage
+
+func (m mailbox) Send(ctx context.Context, msg MailMessage) error {
+	m <- msg
+	return nil
+}
+
+// useVerification gives a test its own verification secret, event hub and
+// mailer, and a pending user of the tenant acme
+func useVerification(t *testing.T) (mailbox, userRecord) {
+	t.Helper()
+	useTenants(t, tenantSettings{})
+	useEventHub(t, 16)
+	savedSecret, savedURL, savedMailer := verifySecret, publicBaseURL, mailer
+	t.Cleanup(func() { verifySecret, publicBaseURL, mailer = savedSecret, savedURL, savedMailer })
+
+	sent := make(mailbox, 4)
+	verifySecret, publicBaseURL, mailer = "verify-secret", "https://api.example.com", sent
+
+	acme, _ := tenants.Get("acme")
+	added, err := acme.store.Add(context.Background(), User{Name: "Ada", Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sent, added[0]
+}
+
+func verifyLink(claims verifyClaims) string {
+	return "/api/verify?token=" + url.QueryEscape(signVerifyToken(claims))
+}
+
+func openVerifyLink(link string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handleVerifyEmail(w, httptest.NewRequest(http.MethodGet, link, nil))
+	return w
+}
+
+func TestVerifyEmail(t *testing.T) {
+	_, user := useVerification(t)
+	claims := verifyClaims{Tenant: "acme", User: user.PublicID, Email: user.Email, Exp: time.Now().Add(time.Hour).Unix()}
+
+	if w := openVerifyLink(verifyLink(claims)); w.Code != http.StatusOK {
+		t.Fatalf("valid link got %d: %s", w.Code, w.Body)
+	}
+	acme, _ := tenants.Get("acme")
+	if record, _ := acme.store.Get(context.Background(), user.PublicID); record.Status != UserActive {
+		t.Errorf("user is %s after verifying, want %s", record.Status, UserActive)
+	}
+	if w := openVerifyLink(verifyLink(claims)); w.Code != http.StatusConflict {
+		t.Errorf("second use of the link got %d, want 409", w.Code)
+	}
+}
+
+func TestVerifyEmailRejectsTamperedLinks(t *testing.T) {
+	_, user := useVerification(t)
+	acme, _ := tenants.Get("acme")
+	other, err := acme.store.Add(context.Background(), User{Name: "Grace", Email: "grace@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := verifyClaims{Tenant: "acme", User: user.PublicID, Email: user.Email, Exp: time.Now().Add(time.Hour).Unix()}
+	token := signVerifyToken(claims)
+
+	// the claims of another user under the signature of this one
+	forgedClaims := claims
+	forgedClaims.User = other[0].PublicID
+	payload, _ := json.Marshal(forgedClaims)
+	parts := strings.Split(token, ".")
+	swapped := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+
+	verifySecret = "guessed"
+	otherSecret := signVerifyToken(claims)
+	verifySecret = "verify-secret"
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"claims changed", swapped},
+		{"signed with another secret", otherSecret},
+		{"signature cut off", parts[0] + "." + parts[1] + "."},
+		{"not a token", "not-a-token"},
+		{"no token", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := openVerifyLink("/api/verify?token=" + url.QueryEscape(tt.token)); w.Code != http.StatusBadRequest {
+				t.Errorf("got %d, want 400: %s", w.Code, w.Body)
+			}
+		})
+	}
+	for _, id := range []string{user.PublicID, other[0].PublicID} {
+		if record, _ := acme.store.Get(context.Background(), id); record.Status != UserPending {
+			t.Errorf("user %s was verified by a tampered link", id)
+		}
+	}
+}
+
+var linkToken = regexp.MustCompile(`/api/verify\?token=(\S+)`)
+
+func TestVerifyEmailExpiredLinkSendsANewOne(t *testing.T) {
+	sent, user := useVerification(t)
+	expired := verifyClaims{Tenant: "acme", User: user.PublicID, Email: user.Email, Exp: time.Now().Add(-time.Minute).Unix()}
+
+	if w := openVerifyLink(verifyLink(expired)); w.Code != http.StatusGone {
+		t.Fatalf("expired link got %d, want 410: %s", w.Code, w.Body)
+	}
+	acme, _ := tenants.Get("acme")
+	if record, _ := acme.store.Get(context.Background(), user.PublicID); record.Status != UserPending {
+		t.Errorf("an expired link verified the user")
+	}
+
+	msg := waitFor(t, sent, "the new link")
+	match := linkToken.FindStringSubmatch(msg.Body)
+	if msg.To != user.Email || match == nil {
+		t.Fatalf("new link mail to %s: %q", msg.To, msg.Body)
+	}
+	if w := openVerifyLink("/api/verify?token=" + match[1]); w.Code != http.StatusOK {
+		t.Errorf("the new link got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestVerifyEmailExpiredLinkOfVerifiedUser(t *testing.T) {
+	sent, user := useVerification(t)
+	acme, _ := tenants.Get("acme")
+	if _, err := acme.store.Verify(context.Background(), user.PublicID, user.Email); err != nil {
+		t.Fatal(err)
+	}
+	expired := verifyClaims{Tenant: "acme", User: user.PublicID, Email: user.Email, Exp: time.Now().Add(-time.Minute).Unix()}
+
+	if w := openVerifyLink(verifyLink(expired)); w.Code != http.StatusConflict {
+		t.Errorf("expired link of a verified user got %d, want 409", w.Code)
+	}
+	select {
+	case msg := <-sent:
+		t.Errorf("a new link was sent to a verified user: %q", msg.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestVerifyEmailLinkForOldAddress(t *testing.T) {
+	_, user := useVerification(t)
+	acme, _ := tenants.Get("acme")
+	if _, err := acme.store.Update(context.Background(), user.PublicID, User{Name: user.Name, Email: "ada@example.org"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []time.Duration{time.Hour, -time.Minute} {
+		claims := verifyClaims{Tenant: "acme", User: user.PublicID, Email: user.Email, Exp: time.Now().Add(exp).Unix()}
+		if w := openVerifyLink(verifyLink(claims)); w.Code != http.StatusGone {
+			t.Errorf("link for the old address (expires in %s) got %d, want 410", exp, w.Code)
+		}
+	}
+	if record, _ := acme.store.Get(context.Background(), user.PublicID); record.Status != UserPending {
+		t.Error("a link for the old address verified the new one")
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserV2 has an opaque string ID and real timestamps
+type UserV2 struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Email      string     `json:"email"`
+	Status     string     `json:"status"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// UserV2Input is the body accepted by v2 create and update
+type UserV2Input struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (u UserV2Input) Validate() error {
+	return validateUser(u.Name, u.Email)
+}
+
+func (u userRecord) V2() UserV2 {
+	return UserV2{
+		ID:         u.PublicID,
+		Name:       u.Name,
+		Email:      u.Email,
+		Status:     u.Status,
+		VerifiedAt: u.VerifiedAt,
+		CreatedAt:  u.CreatedAt,
+		UpdatedAt:  u.UpdatedAt,
+	}
+}
+
+func usersV1(records []userRecord) []User {
+	users := make([]User, len(records))
+	for i, record := range records {
+		users[i] = record.V1()
+	}
+	return users
+}
+
+func usersV2(records []userRecord) []UserV2 {
+	users := make([]UserV2, len(records))
+	for i, record := range records {
+		users[i] = record.V2()
+	}
+	return users
+}
+
+// v1 has been deprecated since this date in favour of /api/v2
+var v1DeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// deprecatedV1 marks a v1 response as deprecated (RFC 9745) and links to v2
+func deprecatedV1(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(v1DeprecatedAt.Unix(), 10))
+		w.Header().Set("Link", `</api/v2/users>; rel="successor-version"`)
+		handler(w, r)
+	}
+}
+
+// v1Alias serves /api/v1/... with the handler written for the unversioned /api/... path
+func v1Alias(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/api" + strings.TrimPrefix(r.URL.Path, "/api/v1")
+		handler(w, r2)
+	}
+}
+
+// V2 list handler function
+func handleListUsersV2(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	query := r.URL.Query()
+
+	var after, before time.Time
+	for name, target := range map[string]*time.Time{"created_after": &after, "created_before": &before} {
+		if raw := query.Get(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				http.Error(w, name+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+				logEndpoint(r, startTime, http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+
+	all, err := storeFor(r).List(r.Context())
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	var records []userRecord
+	for _, record := range all {
+		if !after.IsZero() && !record.CreatedAt.After(after) {
+			continue
+		}
+		if !before.IsZero() && !record.CreatedAt.Before(before) {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sortKey := query.Get("sort")
+	descending := strings.HasPrefix(sortKey, "-")
+	var less func(a, b userRecord) bool
+	switch strings.TrimPrefix(sortKey, "-") {
+	case "", "created_at":
+		less = func(a, b userRecord) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	case "updated_at":
+		less = func(a, b userRecord) bool { return a.UpdatedAt.Before(b.UpdatedAt) }
+	case "name":
+		less = func(a, b userRecord) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	default:
+		http.Error(w, "sort must be created_at, updated_at or name, optionally prefixed with -", http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if descending {
+			return less(records[j], records[i])
+		}
+		return less(records[i], records[j])
+	})
+
+	sendResponse(w, r, Response{
+		Message: "Users retrieved successfully",
+		Status:  http.StatusOK,
+		Data:    usersV2(records),
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// V2 create handler function
+func handleCreateUserV2(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	var input UserV2Input
+	if err := decodeBody(r, &input); err != nil {
+		if requestAborted(w, r, startTime, err) {
+			return
+		}
+		http.Error(w, err.Error(), decodeStatus(err))
+		logEndpoint(r, startTime, decodeStatus(err))
+		return
+	}
+	if err := input.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+
+	added, err := storeFor(r).Add(r.Context(), User{Name: input.Name, Email: input.Email})
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		logEndpoint(r, startTime, http.StatusForbidden)
+		return
+	}
+	record := added[0]
+	saveUsersToFile(r.Context())
+	requestVerification(r, record)
+	publishEvent(r.Context(), EventUserCreated, record.V1())
+
+	w.Header().Set("Location", "/api/v2/users/"+record.PublicID)
+	sendResponse(w, r, Response{
+		Message: "User created successfully",
+		Status:  http.StatusCreated,
+		Data:    record.V2(),
+	})
+	logEndpoint(r, startTime, http.StatusCreated)
+}
+
+// userIDV2 reads the opaque ID from /api/v2/users/{id}; numeric v1 IDs are not accepted
+func userIDV2(r *http.Request) (string, bool) {
+	id := getUserIDFromURL(r.URL.Path, "/api/v2/users/")
+	return id, strings.HasPrefix(id, "usr_")
+}
+
+// V2 get handler function
+func handleGetUserV2(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	id, ok := userIDV2(r)
+	record, err := storeFor(r).Get(r.Context(), id)
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if !ok || err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+
+	sendResponse(w, r, Response{
+		Message: "User retrieved successfully",
+		Status:  http.StatusOK,
+		Data:    record.V2(),
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// V2 update handler function
+func handleUpdateUserV2(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	id, ok := userIDV2(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+
+	var input UserV2Input
+	if err := decodeBody(r, &input); err != nil {
+		if requestAborted(w, r, startTime, err) {
+			return
+		}
+		http.Error(w, err.Error(), decodeStatus(err))
+		logEndpoint(r, startTime, decodeStatus(err))
+		return
+	}
+	if err := input.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+
+	record, err := storeFor(r).Update(r.Context(), id, User{Name: input.Name, Email: input.Email})
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+	saveUsersToFile(r.Context())
+	requestVerification(r, record)
+	publishEvent(r.Context(), EventUserUpdated, record.V1())
+
+	sendResponse(w, r, Response{
+		Message: "User updated successfully",
+		Status:  http.StatusOK,
+		Data:    record.V2(),
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// V2 delete handler function
+func handleDeleteUserV2(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	id, ok := userIDV2(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+
+	removed, err := storeFor(r).Delete(r.Context(), id)
+	if requestAborted(w, r, startTime, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+	saveUsersToFile(r.Context())
+	publishEvent(r.Context(), EventUserDeleted, removed.V1())
+
+	sendResponse(w, r, Response{
+		Message: "User deleted successfully",
+		Status:  http.StatusOK,
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
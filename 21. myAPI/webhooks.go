@@ -0,0 +1,638 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Webhook event names
+const (
+	EventUserCreated   = "user.created"
+	EventUserUpdated   = "user.updated"
+	EventUserDeleted   = "user.deleted"
+	EventFormSubmitted = "form.submitted"
+	EventFileUploaded  = "file.uploaded"
+)
+
+var webhookEvents = []string{EventUserCreated, EventUserUpdated, EventUserDeleted, EventFormSubmitted, EventFileUploaded}
+
+// Webhook data structures
+type WebhookSubscription struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"created_at"`
+	TenantID  string   `json:"tenant_id"`
+}
+
+type WebhookPayload struct {
+	ID        string `json:"id"`
+	Event     string `json:"event"`
+	CreatedAt string `json:"created_at"`
+	Data      any    `json:"data,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	TenantID       string          `json:"tenant_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Attempts       int             `json:"attempts"`
+	NextAttempt    time.Time       `json:"next_attempt"`
+	LastError      string          `json:"last_error,omitempty"`
+}
+
+// Matches reports whether the subscription wants the given event
+func (s WebhookSubscription) Matches(event string) bool {
+	return slices.Contains(s.Events, "*") || slices.Contains(s.Events, event)
+}
+
+// WebhookDispatcher stores subscriptions and delivers events to them from a
+// queue that is persisted to disk, so pending deliveries survive a restart.
+// Up to Workers subscriptions are delivered to at once, each by one worker.
+// A subscription's deliveries go out in the order they were published: a
+// failing delivery holds back the ones behind it until it succeeds or is
+// dead-lettered. A slow receiver only holds up its own deliveries.
+type WebhookDispatcher struct {
+	Client       *http.Client
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Workers      int
+	PollInterval time.Duration
+	SaveDelay    time.Duration // how long queue changes are batched before they are written
+
+	mu        sync.Mutex
+	file      string
+	state     webhookState
+	dirty     bool            // the queue changed since the last save
+	saveTimer *time.Timer     // pending batched save
+	busy      map[string]bool // subscriptions a worker is delivering to
+	wake      chan struct{}
+	heartbeat atomic.Int64 // unix nanoseconds of the last Run loop iteration
+}
+
+type webhookState struct {
+	Subscriptions []WebhookSubscription `json:"subscriptions"`
+	Queue         []WebhookDelivery     `json:"queue"`
+	DeadLetters   []WebhookDelivery     `json:"dead_letters"`
+}
+
+func NewWebhookDispatcher(file string) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:  8,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Hour,
+		Workers:      8,
+		PollInterval: time.Second,
+		SaveDelay:    200 * time.Millisecond,
+		file:         file,
+		busy:         map[string]bool{},
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+var webhooks = NewWebhookDispatcher("webhooks.json")
+
+// Load reads subscriptions and pending deliveries from the dispatcher file
+func (d *WebhookDispatcher) Load() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data, err := os.ReadFile(d.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &d.state); err != nil {
+		return err
+	}
+
+	// Files written before tenancy belong to the default tenant
+	for i := range d.state.Subscriptions {
+		if d.state.Subscriptions[i].TenantID == "" {
+			d.state.Subscriptions[i].TenantID = defaultTenantID
+		}
+	}
+	for _, list := range [][]WebhookDelivery{d.state.Queue, d.state.DeadLetters} {
+		for i := range list {
+			if list[i].TenantID == "" {
+				list[i].TenantID = defaultTenantID
+			}
+		}
+	}
+	return nil
+}
+
+// save writes the dispatcher state atomically; callers must hold d.mu
+func (d *WebhookDispatcher) save() error {
+	data, err := json.MarshalIndent(d.state, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := d.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, d.file); err != nil {
+		return err
+	}
+	d.dirty = false
+	return nil
+}
+
+// saveLater marks the state changed and writes it after SaveDelay, so a
+// burst of events is saved once; callers must hold d.mu
+func (d *WebhookDispatcher) saveLater() {
+	d.dirty = true
+	if d.saveTimer != nil {
+		return
+	}
+	d.saveTimer = time.AfterFunc(d.SaveDelay, func() {
+		if err := d.Flush(); err != nil {
+			fmt.Printf("Error saving webhook queue: %s\n", err)
+		}
+	})
+}
+
+// Flush writes changes still waiting for a batched save
+func (d *WebhookDispatcher) Flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.saveTimer != nil {
+		d.saveTimer.Stop()
+		d.saveTimer = nil
+	}
+	if !d.dirty {
+		return nil
+	}
+	return d.save()
+}
+
+// Subscribe validates and stores a new subscription for a tenant, generating a secret if none is given
+func (d *WebhookDispatcher) Subscribe(tenantID string, sub WebhookSubscription) (WebhookSubscription, error) {
+	target, err := url.Parse(sub.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return sub, errors.New("url must be an absolute http or https URL")
+	}
+	if len(sub.Events) == 0 {
+		return sub, errors.New("events must list at least one event")
+	}
+	for _, event := range sub.Events {
+		if event != "*" && !slices.Contains(webhookEvents, event) {
+			return sub, fmt.Errorf("unknown event %q", event)
+		}
+	}
+	if sub.Secret == "" {
+		sub.Secret = randomHex(32)
+	}
+	sub.ID = "wh_" + randomHex(8)
+	sub.CreatedAt = time.Now().Format(time.RFC3339)
+	sub.TenantID = tenantID
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.state.Subscriptions = append(d.state.Subscriptions, sub)
+	return sub, d.save()
+}
+
+// Subscriptions returns the subscriptions of a tenant without their secrets
+func (d *WebhookDispatcher) Subscriptions(tenantID string) []WebhookSubscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subs := []WebhookSubscription{}
+	for _, sub := range d.state.Subscriptions {
+		if sub.TenantID == tenantID {
+			sub.Secret = ""
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// Unsubscribe removes a tenant's subscription and drops its pending and
+// dead-lettered deliveries
+func (d *WebhookDispatcher) Unsubscribe(tenantID, id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := slices.IndexFunc(d.state.Subscriptions, func(s WebhookSubscription) bool { return s.ID == id && s.TenantID == tenantID })
+	if i < 0 {
+		return false, nil
+	}
+	d.state.Subscriptions = slices.Delete(d.state.Subscriptions, i, i+1)
+	d.state.Queue = slices.DeleteFunc(d.state.Queue, func(del WebhookDelivery) bool { return del.SubscriptionID == id })
+	d.state.DeadLetters = slices.DeleteFunc(d.state.DeadLetters, func(del WebhookDelivery) bool { return del.SubscriptionID == id })
+	return true, d.save()
+}
+
+// UnsubscribeTenant removes everything a deleted tenant had queued or subscribed
+func (d *WebhookDispatcher) UnsubscribeTenant(tenantID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.state.Subscriptions = slices.DeleteFunc(d.state.Subscriptions, func(s WebhookSubscription) bool { return s.TenantID == tenantID })
+	d.state.Queue = slices.DeleteFunc(d.state.Queue, func(del WebhookDelivery) bool { return del.TenantID == tenantID })
+	d.state.DeadLetters = slices.DeleteFunc(d.state.DeadLetters, func(del WebhookDelivery) bool { return del.TenantID == tenantID })
+	return d.save()
+}
+
+// DeadLetters returns a tenant's deliveries that ran out of attempts
+func (d *WebhookDispatcher) DeadLetters(tenantID string) []WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	letters := []WebhookDelivery{}
+	for _, delivery := range d.state.DeadLetters {
+		if delivery.TenantID == tenantID {
+			letters = append(letters, delivery)
+		}
+	}
+	return letters
+}
+
+// Requeue moves a dead letter back onto the queue with a fresh attempt count
+func (d *WebhookDispatcher) Requeue(tenantID, id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := slices.IndexFunc(d.state.DeadLetters, func(del WebhookDelivery) bool { return del.ID == id && del.TenantID == tenantID })
+	if i < 0 {
+		return false, nil
+	}
+	delivery := d.state.DeadLetters[i]
+	delivery.Attempts = 0
+	delivery.NextAttempt = time.Now()
+	d.state.DeadLetters = slices.Delete(d.state.DeadLetters, i, i+1)
+	d.state.Queue = append(d.state.Queue, delivery)
+	d.notify()
+	return true, d.save()
+}
+
+// Publish queues one delivery per matching subscription of the tenant. The
+// queue is saved after SaveDelay, together with whatever else changed by then.
+func (d *WebhookDispatcher) Publish(tenantID, event string, data any) error {
+	payload, err := json.Marshal(WebhookPayload{
+		ID:        "evt_" + randomHex(8),
+		Event:     event,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queued := 0
+	for _, sub := range d.state.Subscriptions {
+		if sub.TenantID != tenantID || !sub.Matches(event) {
+			continue
+		}
+		d.state.Queue = append(d.state.Queue, WebhookDelivery{
+			ID:             "dlv_" + randomHex(8),
+			SubscriptionID: sub.ID,
+			TenantID:       tenantID,
+			Event:          event,
+			Payload:        payload,
+			NextAttempt:    time.Now(),
+		})
+		queued++
+	}
+	if queued > 0 {
+		d.saveLater()
+		d.notify()
+	}
+	return nil
+}
+
+func (d *WebhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due webhooks until stop is closed, then waits for the
+// deliveries in flight and saves the queue
+func (d *WebhookDispatcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	slots := make(chan struct{}, max(d.Workers, 1))
+	var workers sync.WaitGroup
+
+	for {
+		d.heartbeat.Store(time.Now().UnixNano())
+		d.deliverDue(slots, &workers)
+		select {
+		case <-stop:
+			workers.Wait()
+			if err := d.Flush(); err != nil {
+				fmt.Printf("Error saving webhook queue: %s\n", err)
+			}
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue starts a worker for every subscription with a delivery due
+// while a slot is free. A worker that finishes frees its slot and wakes
+// Run, so subscriptions left waiting are picked up straight away.
+func (d *WebhookDispatcher) deliverDue(slots chan struct{}, workers *sync.WaitGroup) {
+	for {
+		select {
+		case slots <- struct{}{}:
+		default:
+			return
+		}
+		subID, ok := d.claimDue()
+		if !ok {
+			<-slots
+			return
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			d.deliverTo(subID)
+			<-slots
+			d.notify()
+		}()
+	}
+}
+
+// deliverTo sends a subscription's deliveries one at a time, in queue
+// order, until the first one left is not due yet
+func (d *WebhookDispatcher) deliverTo(subID string) {
+	for {
+		delivery, secret, url, ok := d.nextDue(subID)
+		if !ok {
+			return
+		}
+		err := d.send(url, secret, delivery)
+		d.finish(delivery, err)
+		d.heartbeat.Store(time.Now().UnixNano())
+	}
+}
+
+// Check reports whether the delivery worker is running and making progress
+func (d *WebhookDispatcher) Check(ctx context.Context) error {
+	last := d.heartbeat.Load()
+	if last == 0 {
+		return errors.New("delivery worker is not running")
+	}
+	if since := time.Since(time.Unix(0, last)); since > time.Minute {
+		return fmt.Errorf("delivery worker has not made progress for %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+// claimDue marks a subscription whose first queued delivery is due, and
+// that no worker has, as busy and returns it
+func (d *WebhookDispatcher) claimDue() (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	seen := map[string]bool{}
+	for _, delivery := range d.state.Queue {
+		if seen[delivery.SubscriptionID] {
+			continue // only the first delivery of a subscription can go out
+		}
+		seen[delivery.SubscriptionID] = true
+		if delivery.NextAttempt.After(now) || d.busy[delivery.SubscriptionID] {
+			continue
+		}
+		if slices.ContainsFunc(d.state.Subscriptions, func(s WebhookSubscription) bool { return s.ID == delivery.SubscriptionID }) {
+			d.busy[delivery.SubscriptionID] = true
+			return delivery.SubscriptionID, true
+		}
+	}
+	return "", false
+}
+
+// nextDue returns the subscription's first queued delivery, when it is due,
+// with the secret and URL. Otherwise the subscription is released, under
+// the same lock, so a delivery queued meanwhile is claimed again by Run.
+func (d *WebhookDispatcher) nextDue(subID string) (WebhookDelivery, string, string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	i := slices.IndexFunc(d.state.Subscriptions, func(s WebhookSubscription) bool { return s.ID == subID })
+	if i >= 0 {
+		sub := d.state.Subscriptions[i]
+		j := slices.IndexFunc(d.state.Queue, func(del WebhookDelivery) bool { return del.SubscriptionID == subID })
+		if j >= 0 && !d.state.Queue[j].NextAttempt.After(now) {
+			return d.state.Queue[j], sub.Secret, sub.URL, true
+		}
+	}
+	delete(d.busy, subID)
+	return WebhookDelivery{}, "", "", false
+}
+
+// finish records the outcome of one attempt. A failure is rescheduled where
+// it is in the queue, so the subscription's later deliveries wait for it,
+// or dead-lettered once it runs out of attempts.
+func (d *WebhookDispatcher) finish(delivery WebhookDelivery, sendErr error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := slices.IndexFunc(d.state.Queue, func(del WebhookDelivery) bool { return del.ID == delivery.ID })
+	if i < 0 {
+		return
+	}
+	defer d.saveLater()
+
+	if sendErr == nil {
+		d.state.Queue = slices.Delete(d.state.Queue, i, i+1)
+		return
+	}
+	delivery.Attempts++
+	delivery.LastError = sendErr.Error()
+	if delivery.Attempts >= d.MaxAttempts {
+		d.state.Queue = slices.Delete(d.state.Queue, i, i+1)
+		d.state.DeadLetters = append(d.state.DeadLetters, delivery)
+		fmt.Printf("Webhook %s dead-lettered after %d attempts: %s\n", delivery.ID, delivery.Attempts, sendErr)
+		return
+	}
+	delivery.NextAttempt = time.Now().Add(d.backoff(delivery.Attempts))
+	d.state.Queue[i] = delivery
+}
+
+// backoff doubles the delay after each failed attempt, up to MaxDelay
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < attempts && delay < d.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, d.MaxDelay)
+}
+
+func (d *WebhookDispatcher) send(url, secret string, delivery WebhookDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(secret, timestamp, delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhook computes the HMAC-SHA256 of "timestamp.payload". Receivers
+// recompute it with their secret and compare using hmac.Equal.
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Webhook list handler function
+func handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	sendResponse(w, r, Response{
+		Message: "Webhooks retrieved successfully",
+		Status:  http.StatusOK,
+		Data:    webhooks.Subscriptions(tenantFrom(r.Context()).ID),
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// Webhook create handler function
+func handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	var sub WebhookSubscription
+	if err := decodeBody(r, &sub); err != nil {
+		http.Error(w, err.Error(), decodeStatus(err))
+		logEndpoint(r, startTime, decodeStatus(err))
+		return
+	}
+
+	sub, err := webhooks.Subscribe(tenantFrom(r.Context()).ID, sub)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logEndpoint(r, startTime, http.StatusBadRequest)
+		return
+	}
+
+	// The secret is only ever returned here, when the subscription is created
+	sendResponse(w, r, Response{
+		Message: "Webhook created successfully",
+		Status:  http.StatusCreated,
+		Data:    sub,
+	})
+	logEndpoint(r, startTime, http.StatusCreated)
+}
+
+// Webhook delete handler function
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	id := getUserIDFromURL(r.URL.Path, "/api/webhooks/")
+	found, err := webhooks.Unsubscribe(tenantFrom(r.Context()).ID, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logEndpoint(r, startTime, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+
+	sendResponse(w, r, Response{
+		Message: "Webhook deleted successfully",
+		Status:  http.StatusOK,
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// Dead letter list handler function
+func handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	sendResponse(w, r, Response{
+		Message: "Dead letters retrieved successfully",
+		Status:  http.StatusOK,
+		Data:    webhooks.DeadLetters(tenantFrom(r.Context()).ID),
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
+
+// Dead letter requeue handler function
+func handleRequeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	id := getUserIDFromURL(r.URL.Path, "/api/webhooks/dead-letters/")
+	found, err := webhooks.Requeue(tenantFrom(r.Context()).ID, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logEndpoint(r, startTime, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		logEndpoint(r, startTime, http.StatusNotFound)
+		return
+	}
+
+	sendResponse(w, r, Response{
+		Message: "Delivery requeued successfully",
+		Status:  http.StatusOK,
+	})
+	logEndpoint(r, startTime, http.StatusOK)
+}
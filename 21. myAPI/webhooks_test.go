@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startDispatcher runs a dispatcher with a queue file in a temporary
+// directory and short delays, and stops it when the test ends
+func startDispatcher(t *testing.T) *WebhookDispatcher {
+	t.Helper()
+	d := NewWebhookDispatcher(filepath.Join(t.TempDir(), "webhooks.json"))
+	d.BaseDelay = 20 * time.Millisecond
+	d.MaxDelay = time.Second
+	d.PollInterval = 5 * time.Millisecond
+	d.Client.Timeout = 5 * time.Second
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		d.Run(stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	return d
+}
+
+func subscribe(t *testing.T, d *WebhookDispatcher, tenantID, url, secret string) WebhookSubscription {
+	t.Helper()
+	sub, err := d.Subscribe(tenantID, WebhookSubscription{URL: url, Events: []string{"*"}, Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+// received is one request a test receiver got
+type received struct {
+	at      time.Time
+	header  http.Header
+	payload []byte
+}
+
+func waitFor[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		panic("unreachable")
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	got := make(chan received, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := io.ReadAll(r.Body)
+		got <- received{time.Now(), r.Header.Clone(), payload}
+	}))
+	defer receiver.Close()
+
+	d := startDispatcher(t)
+	subscribe(t, d, "acme", receiver.URL, "s3cret")
+	if err := d.Publish("acme", EventUserCreated, map[string]any{"id": 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := waitFor(t, got, "the delivery")
+	timestamp := req.header.Get("X-Webhook-Timestamp")
+	want := "sha256=" + signWebhook("s3cret", timestamp, req.payload)
+	if sig := req.header.Get("X-Webhook-Signature"); !hmac.Equal([]byte(sig), []byte(want)) {
+		t.Errorf("signature %q, want %q", sig, want)
+	}
+	if other := "sha256=" + signWebhook("other", timestamp, req.payload); other == want {
+		t.Error("signature does not depend on the secret")
+	}
+	if event := req.header.Get("X-Webhook-Event"); event != EventUserCreated {
+		t.Errorf("event header %q", event)
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != EventUserCreated || !strings.HasPrefix(payload.ID, "evt_") {
+		t.Errorf("payload %+v", payload)
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []time.Time
+	delivered := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, time.Now())
+		if len(attempts) < 3 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		close(delivered)
+	}))
+	defer receiver.Close()
+
+	d := startDispatcher(t)
+	subscribe(t, d, "acme", receiver.URL, "s3cret")
+	if err := d.Publish("acme", EventUserUpdated, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, delivered, "the third attempt")
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the delay doubles: BaseDelay after the first failure, twice it after the second
+	for i, want := range []time.Duration{d.BaseDelay, 2 * d.BaseDelay} {
+		if gap := attempts[i+1].Sub(attempts[i]); gap < want {
+			t.Errorf("attempt %d came %s after the one before, want at least %s", i+2, gap, want)
+		}
+	}
+	if letters := d.DeadLetters("acme"); len(letters) != 0 {
+		t.Errorf("dead letters after a successful retry: %+v", letters)
+	}
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	d := startDispatcher(t)
+	d.MaxAttempts = 3
+	sub := subscribe(t, d, "acme", receiver.URL, "s3cret")
+	if err := d.Publish("acme", EventUserDeleted, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var letters []WebhookDelivery
+	for len(letters) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		letters = d.DeadLetters("acme")
+	}
+	if len(letters) != 1 {
+		t.Fatalf("dead letters %+v, want one", letters)
+	}
+	letter := letters[0]
+	if letter.Attempts != 3 || letter.SubscriptionID != sub.ID || !strings.Contains(letter.LastError, "500") {
+		t.Errorf("dead letter %+v", letter)
+	}
+	mu.Lock()
+	if calls != 3 {
+		t.Errorf("receiver was called %d times, want 3", calls)
+	}
+	mu.Unlock()
+	if others := d.DeadLetters("other"); len(others) != 0 {
+		t.Errorf("another tenant sees dead letters %+v", others)
+	}
+
+	found, err := d.Requeue("acme", letter.ID)
+	if err != nil || !found {
+		t.Fatalf("requeue: %v, %v", found, err)
+	}
+	if letters := d.DeadLetters("acme"); len(letters) != 0 {
+		t.Errorf("dead letters after requeue: %+v", letters)
+	}
+}
+
+func TestSlowWebhookReceiverDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	slowStarted := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowStarted <- struct{}{}
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	fastGot := make(chan received, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastGot <- received{at: time.Now()}
+	}))
+	defer fast.Close()
+
+	d := startDispatcher(t)
+	subscribe(t, d, "slow-tenant", slow.URL, "a")
+	subscribe(t, d, "fast-tenant", fast.URL, "b")
+
+	if err := d.Publish("slow-tenant", EventUserCreated, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, slowStarted, "the slow receiver")
+	if err := d.Publish("fast-tenant", EventUserCreated, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, fastGot, "the fast receiver while the slow one is busy")
+}
+
+func TestWebhookFailureHoldsBackLaterDeliveries(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	failures := 2
+	done := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		event := r.Header.Get("X-Webhook-Event")
+		if event == EventUserCreated && failures > 0 {
+			failures--
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		order = append(order, event)
+		if len(order) == 3 {
+			close(done)
+		}
+	}))
+	defer receiver.Close()
+
+	d := startDispatcher(t)
+	subscribe(t, d, "acme", receiver.URL, "s3cret")
+	for _, event := range []string{EventUserCreated, EventUserUpdated, EventUserDeleted} {
+		if err := d.Publish("acme", event, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, done, "all three deliveries")
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{EventUserCreated, EventUserUpdated, EventUserDeleted}
+	if !slices.Equal(order, want) {
+		t.Errorf("delivered in order %v, want %v", order, want)
+	}
+}
+
+func TestUnsubscribeDropsDeadLetters(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	d := startDispatcher(t)
+	d.MaxAttempts = 1
+	sub := subscribe(t, d, "acme", receiver.URL, "s3cret")
+	if err := d.Publish("acme", EventUserCreated, nil); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.DeadLetters("acme")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(d.DeadLetters("acme")) != 1 {
+		t.Fatal("the delivery was not dead-lettered")
+	}
+
+	if found, err := d.Unsubscribe("acme", sub.ID); err != nil || !found {
+		t.Fatalf("unsubscribe: %v, %v", found, err)
+	}
+	if letters := d.DeadLetters("acme"); len(letters) != 0 {
+		t.Errorf("dead letters of a deleted subscription: %+v", letters)
+	}
+}
+
+func TestPublishSavesInBatches(t *testing.T) {
+	d := NewWebhookDispatcher(filepath.Join(t.TempDir(), "webhooks.json"))
+	d.SaveDelay = time.Hour
+	subscribe(t, d, "acme", "http://127.0.0.1:1/hook", "s3cret")
+	for range 3 {
+		if err := d.Publish("acme", EventUserCreated, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved := func() int {
+		reloaded := NewWebhookDispatcher(d.file)
+		if err := reloaded.Load(); err != nil {
+			t.Fatal(err)
+		}
+		return len(reloaded.state.Queue)
+	}
+	if n := saved(); n != 0 {
+		t.Errorf("%d deliveries saved before the batch delay, want 0", n)
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := saved(); n != 3 {
+		t.Errorf("%d deliveries saved after Flush, want 3", n)
+	}
+}
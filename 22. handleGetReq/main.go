@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 )
 
 // Data Structures
@@ -28,6 +31,13 @@ type Response struct {
 
 const baseURL = "http://localhost:8080/api"
 
+// POST requests are retried with the same Idempotency-Key, so a retry
+// after a timeout cannot create a second user
+const (
+	postAttempts = 3
+	postTimeout  = 10 * time.Second
+)
+
 func main() {
 	for {
 		choice := displayMenu()
@@ -255,7 +265,27 @@ func makeGetRequest(endpoint string) (*http.Response, error) {
 }
 
 func makePostRequest(endpoint string, jsonData []byte) (*http.Response, error) {
-	return http.Post(baseURL+endpoint, "application/json", bytes.NewBuffer(jsonData))
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	client := &http.Client{Timeout: postTimeout}
+	var lastErr error
+	for attempt := 1; attempt <= postAttempts; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, baseURL+endpoint, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", hex.EncodeToString(key))
+
+		resp, err := client.Do(req)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		fmt.Printf("Attempt %d failed: %v\n", attempt, err)
+	}
+	return nil, lastErr
 }
 
 func makePutRequest(endpoint string, jsonData []byte) (*http.Response, error) {
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// author controllers - file
+
+func getAllAuthors(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get all authors")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(catalog.Authors())
+}
+
+func getOneAuthor(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get one author")
+	w.Header().Set("Content-Type", "application/json")
+
+	author, err := catalog.GetAuthor(mux.Vars(r)["id"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(author)
+}
+
+func createOneAuthor(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Create one author")
+	w.Header().Set("Content-Type", "application/json")
+
+	var author Author
+	if !decodeJSON(w, r, &author) {
+		return
+	}
+	if author.IsEmpty() {
+		writeFieldError(w, http.StatusUnprocessableEntity, "fullname", "fullname is required")
+		return
+	}
+	if author.AuthorId != "" {
+		writeFieldError(w, http.StatusUnprocessableEntity, "authorid", "authorid is assigned by the server, leave it out")
+		return
+	}
+
+	author, err := catalog.AddAuthor(author)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	writeCreated(w, "/authors/"+author.AuthorId)
+	json.NewEncoder(w).Encode(author)
+}
+
+func updateOneAuthor(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Update one author")
+	w.Header().Set("Content-Type", "application/json")
+
+	params := mux.Vars(r)
+
+	var replacement Author
+	if !decodeJSON(w, r, &replacement) {
+		return
+	}
+	if replacement.IsEmpty() {
+		writeFieldError(w, http.StatusUnprocessableEntity, "fullname", "fullname is required")
+		return
+	}
+	if replacement.AuthorId != "" && replacement.AuthorId != params["id"] {
+		writeFieldError(w, http.StatusUnprocessableEntity, "authorid", "authorid cannot be changed")
+		return
+	}
+
+	author, err := catalog.UpdateAuthor(params["id"], func(a *Author) {
+		*a = replacement
+	})
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(author)
+}
+
+func patchOneAuthor(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Patch one author")
+	w.Header().Set("Content-Type", "application/json")
+
+	var patch AuthorPatch
+	if !decodeJSON(w, r, &patch) {
+		return
+	}
+	if patch.Fullname != nil && *patch.Fullname == "" {
+		writeFieldError(w, http.StatusUnprocessableEntity, "fullname", "fullname cannot be empty")
+		return
+	}
+
+	author, err := catalog.UpdateAuthor(mux.Vars(r)["id"], func(a *Author) {
+		if patch.Fullname != nil {
+			a.Fullname = *patch.Fullname
+		}
+		if patch.Website != nil {
+			a.Website = *patch.Website
+		}
+	})
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(author)
+}
+
+// deleteOneAuthor refuses with 409 while the author has courses, unless
+// ?cascade=true asks for the courses to be deleted as well
+func deleteOneAuthor(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Delete one author")
+
+	cascade := false
+	if value := r.URL.Query().Get("cascade"); value != "" {
+		var err error
+		if cascade, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, "cascade must be true or false")
+			return
+		}
+	}
+
+	if _, err := catalog.DeleteAuthor(mux.Vars(r)["id"], cascade); err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getAuthorCourses(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get author courses")
+	w.Header().Set("Content-Type", "application/json")
+
+	courses, err := catalog.CoursesBy(mux.Vars(r)["id"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	writeCourses(w, r, courses)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gen-cert command - file
+
+// runGenCert writes certificates for trying the TLS options locally: a
+// development CA (ca.pem, for clients to trust and for -tls-client-ca), a
+// server certificate for -tls-cert and a client certificate for mutual TLS,
+// each with its key
+func runGenCert(args []string) error {
+	fs := flag.NewFlagSet("buildapi gen-cert", flag.ContinueOnError)
+	dir := fs.String("dir", "certs", "directory to write the PEM files to")
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "comma-separated DNS names and IPs the server certificate is for")
+	days := fs.Int("days", 365, "how many days the certificates are valid")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *days < 1 {
+		return fmt.Errorf("-days must be at least 1")
+	}
+	if err := os.MkdirAll(*dir, 0o700); err != nil {
+		return err
+	}
+
+	ca, err := newDevCA(time.Duration(*days) * 24 * time.Hour)
+	if err != nil {
+		return err
+	}
+	if err := ca.write(*dir); err != nil {
+		return err
+	}
+	if err := ca.issue(*dir, "server", x509.ExtKeyUsageServerAuth, strings.Split(*hosts, ",")); err != nil {
+		return err
+	}
+	if err := ca.issue(*dir, "client", x509.ExtKeyUsageClientAuth, nil); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote ca.pem, server.pem and client.pem with their keys to %s\n", *dir)
+	fmt.Printf("Run: buildapi -addr :4443 -tls-cert %s -tls-key %s\n",
+		filepath.Join(*dir, "server.pem"), filepath.Join(*dir, "server-key.pem"))
+	return nil
+}
+
+// devCA is a throwaway certificate authority for development
+type devCA struct {
+	cert     *x509.Certificate
+	der      []byte
+	key      *ecdsa.PrivateKey
+	validFor time.Duration
+}
+
+func newDevCA(validFor time.Duration) (*devCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := certTemplate("buildAPI Development CA", validFor)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &devCA{cert: cert, der: der, key: key, validFor: validFor}, nil
+}
+
+// write saves the CA as ca.pem and ca-key.pem in dir
+func (ca *devCA) write(dir string) error {
+	if err := writePEMFile(filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.der, 0o644); err != nil {
+		return err
+	}
+	return writeKeyFile(filepath.Join(dir, "ca-key.pem"), ca.key)
+}
+
+// issue signs a new key for name, usable for usage and, for servers, the
+// given hosts, and saves it as name.pem and name-key.pem in dir
+func (ca *devCA) issue(dir, name string, usage x509.ExtKeyUsage, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template, err := certTemplate("buildAPI "+name, ca.validFor)
+	if err != nil {
+		return err
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	for _, host := range hosts {
+		host = strings.TrimSpace(host)
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return err
+	}
+	if err := writePEMFile(filepath.Join(dir, name+".pem"), "CERTIFICATE", der, 0o644); err != nil {
+		return err
+	}
+	return writeKeyFile(filepath.Join(dir, name+"-key.pem"), key)
+}
+
+func certTemplate(commonName string, validFor time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"buildAPI development"}},
+		NotBefore:    now.Add(-time.Hour), // tolerate clocks a little behind
+		NotAfter:     now.Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, nil
+}
+
+// writeKeyFile saves a private key as PKCS #8, readable only by the owner
+func writeKeyFile(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEMFile(path, "PRIVATE KEY", der, 0o600)
+}
+
+func writePEMFile(path, blockType string, der []byte, perm os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// coupon controllers - file
+
+func getAllCoupons(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get all coupons")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(catalog.Coupons())
+}
+
+func getOneCoupon(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get one coupon")
+	w.Header().Set("Content-Type", "application/json")
+
+	coupon, err := catalog.GetCoupon(mux.Vars(r)["code"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(coupon)
+}
+
+func createOneCoupon(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Create one coupon")
+	w.Header().Set("Content-Type", "application/json")
+
+	var coupon Coupon
+	if !decodeJSON(w, r, &coupon) {
+		return
+	}
+
+	coupon, err := catalog.AddCoupon(coupon)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	writeCreated(w, "/coupons/"+url.PathEscape(coupon.Code))
+	json.NewEncoder(w).Encode(coupon)
+}
+
+func deleteOneCoupon(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Delete one coupon")
+
+	if err := catalog.DeleteCoupon(mux.Vars(r)["code"]); err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getCourseQuote prices a course now, with ?coupon= if given, and lists
+// each discount applied
+func getCourseQuote(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Quote one course")
+	w.Header().Set("Content-Type", "application/json")
+
+	quote, err := catalog.Quote(mux.Vars(r)["id"], r.URL.Query().Get("coupon"), time.Now().UTC())
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(quote)
+}
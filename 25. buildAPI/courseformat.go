@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// The content team keeps catalogue dumps in the course format of the
+// createJson lesson: coursename, price, website and tags. On import the
+// website names the author, so every course from one site shares one
+// Author; prices in the file carry no currency and are usually whole
+// units of it, so both are options of the import.
+
+// LegacyCourse is one record of the createJson course format
+type LegacyCourse struct {
+	Name    string   `json:"coursename"`
+	Price   int      `json:"price"`
+	Website string   `json:"website"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// Price units of a catalogue file
+const (
+	PriceMajor = "major" // whole units, e.g. 299 for 299.00 USD
+	PriceMinor = "minor" // minor units, as buildAPI stores them
+)
+
+// ImportOptions says how to read the prices of a file and whether to
+// store anything at all
+type ImportOptions struct {
+	Currency  string
+	PriceUnit string
+	DryRun    bool
+}
+
+func (o *ImportOptions) normalize() error {
+	o.Currency = strings.ToUpper(strings.TrimSpace(o.Currency))
+	if o.Currency == "" {
+		o.Currency = defaultCurrency
+	}
+	if _, ok := currencyExponents[o.Currency]; !ok {
+		return &ValidationError{"currency", "must be a supported ISO 4217 code"}
+	}
+	if o.PriceUnit == "" {
+		o.PriceUnit = PriceMajor
+	}
+	if o.PriceUnit != PriceMajor && o.PriceUnit != PriceMinor {
+		return &ValidationError{"price_unit", "must be major or minor"}
+	}
+	return nil
+}
+
+// Import item statuses
+const (
+	ImportCreated   = "created"
+	ImportDuplicate = "duplicate"
+	ImportInvalid   = "invalid"
+)
+
+// ImportItem reports what became of one record of the file. In a dry run
+// created means the record would be created, and no IDs are given out.
+type ImportItem struct {
+	Index      int    `json:"index"`
+	CourseName string `json:"coursename"`
+	Status     string `json:"status"`
+	CourseId   string `json:"courseid,omitempty"`
+	AuthorId   string `json:"authorid,omitempty"`
+	NewAuthor  bool   `json:"new_author,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ImportReport sums up an import. AuthorsMatched counts the existing
+// authors that created courses were filed under.
+type ImportReport struct {
+	DryRun         bool         `json:"dry_run"`
+	Currency       string       `json:"currency"`
+	PriceUnit      string       `json:"price_unit"`
+	Received       int          `json:"received"`
+	Created        int          `json:"created"`
+	Duplicates     int          `json:"duplicates"`
+	Invalid        int          `json:"invalid"`
+	AuthorsCreated int          `json:"authors_created"`
+	AuthorsMatched int          `json:"authors_matched"`
+	Items          []ImportItem `json:"items"`
+}
+
+// parseLegacyCourses reads a catalogue file: an array of records, or a
+// single record. Fields outside the format are refused.
+func parseLegacyCourses(data []byte) ([]LegacyCourse, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		data = append(append([]byte{'['}, data...), ']')
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var records []LegacyCourse
+	if err := decoder.Decode(&records); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errTrailingData
+	}
+	return records, nil
+}
+
+// websiteKey normalizes a website for matching authors, so that
+// "https://www.LCO.dev/" and "lco.dev" are the same site
+func websiteKey(website string) string {
+	key := strings.ToLower(strings.TrimSpace(website))
+	key = strings.TrimPrefix(key, "https://")
+	key = strings.TrimPrefix(key, "http://")
+	key = strings.TrimPrefix(key, "www.")
+	return strings.TrimRight(key, "/")
+}
+
+// priceScale is how many minor units make one major unit of currency
+func priceScale(currency string) int {
+	scale := 1
+	for range currencyExponents[currency] {
+		scale *= 10
+	}
+	return scale
+}
+
+// Import adds the courses of a catalogue file. Each record's website is
+// matched to an author by websiteKey, and a new author named after the
+// site is created when none matches. A record naming the same course (by
+// case-insensitive name) as an existing course of the same author, or as
+// an earlier record, is a duplicate and skipped; invalid records are
+// skipped too. Everything happens under one lock, so a dry run reports
+// exactly what an import would do at that moment.
+func (s *Catalog) Import(records []LegacyCourse, opts ImportOptions) (ImportReport, error) {
+	if err := opts.normalize(); err != nil {
+		return ImportReport{}, err
+	}
+	report := ImportReport{
+		DryRun:    opts.DryRun,
+		Currency:  opts.Currency,
+		PriceUnit: opts.PriceUnit,
+		Received:  len(records),
+		Items:     []ImportItem{},
+	}
+	scale := 1
+	if opts.PriceUnit == PriceMajor {
+		scale = priceScale(opts.Currency)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// authors by site; the sites of authors a dry run would create are
+	// only marked planned, as those authors get no ID
+	authorOf := map[string]string{}
+	for _, author := range s.authors {
+		key := websiteKey(author.Website)
+		if key == "" {
+			continue
+		}
+		if id, ok := authorOf[key]; !ok || author.AuthorId < id {
+			authorOf[key] = author.AuthorId
+		}
+	}
+	siteOf := map[string]string{} // author ID to site
+	for key, id := range authorOf {
+		siteOf[id] = key
+	}
+	seen := map[string]bool{} // site and lower-case course name
+	for _, course := range s.courses {
+		owner, ok := siteOf[course.AuthorId]
+		if !ok && course.AuthorId != "" {
+			owner = "id:" + course.AuthorId // an author without a website
+		}
+		seen[owner+"\x00"+strings.ToLower(strings.TrimSpace(course.CourseName))] = true
+	}
+	matched := map[string]bool{}
+	planned := map[string]bool{}
+
+	for i, record := range records {
+		item := ImportItem{Index: i, CourseName: record.Name}
+		course := Course{
+			CourseName:  strings.TrimSpace(record.Name),
+			CoursePrice: record.Price * scale,
+			Currency:    opts.Currency,
+			Tags:        record.Tags,
+		}
+		site := websiteKey(record.Website)
+		dedupKey := site + "\x00" + strings.ToLower(course.CourseName)
+
+		var err error
+		switch {
+		case course.CourseName == "":
+			err = &ValidationError{"coursename", "is required"}
+		case record.Price < 0:
+			err = &ValidationError{"price", "cannot be negative"}
+		case record.Price > 0 && course.CoursePrice/scale != record.Price:
+			err = &ValidationError{"price", "is too large"}
+		default:
+			err = normalizePricing(&course)
+		}
+		if err != nil {
+			item.Status, item.Error = ImportInvalid, err.Error()
+			report.Invalid++
+			report.Items = append(report.Items, item)
+			continue
+		}
+		if seen[dedupKey] {
+			item.Status = ImportDuplicate
+			item.AuthorId = authorOf[site]
+			report.Duplicates++
+			report.Items = append(report.Items, item)
+			continue
+		}
+
+		if site != "" {
+			id, exists := authorOf[site]
+			switch {
+			case exists:
+				if !matched[site] && !planned[site] {
+					matched[site] = true
+					report.AuthorsMatched++
+				}
+			case opts.DryRun:
+				if !planned[site] {
+					planned[site] = true
+					item.NewAuthor = true
+					report.AuthorsCreated++
+				}
+			default:
+				author, err := s.addAuthor(Author{Fullname: site, Website: strings.TrimSpace(record.Website)})
+				if err != nil {
+					return ImportReport{}, err
+				}
+				id = author.AuthorId
+				authorOf[site] = id
+				planned[site] = true
+				item.NewAuthor = true
+				report.AuthorsCreated++
+			}
+			course.AuthorId = id
+			item.AuthorId = id
+		}
+
+		if !opts.DryRun {
+			if course, err = s.add(course); err != nil {
+				return ImportReport{}, err
+			}
+			item.CourseId = course.CourseId
+		}
+		seen[dedupKey] = true
+		item.Status = ImportCreated
+		report.Created++
+		report.Items = append(report.Items, item)
+	}
+	return report, nil
+}
+
+// Export writes courses back out in the createJson format, in creation
+// order, with each course's author's website. Prices are converted to
+// whole units when unit is major, rounding half up, since the format has
+// no room for fractions; currency, if set, keeps only courses priced in it.
+func (s *Catalog) Export(currency, unit string) ([]LegacyCourse, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if _, ok := currencyExponents[currency]; currency != "" && !ok {
+		return nil, &ValidationError{"currency", "must be a supported ISO 4217 code"}
+	}
+	if unit == "" {
+		unit = PriceMajor
+	}
+	if unit != PriceMajor && unit != PriceMinor {
+		return nil, &ValidationError{"price_unit", "must be major or minor"}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := []LegacyCourse{}
+	for _, entry := range s.index.byCreated {
+		course := s.courses[entry.id]
+		if currency != "" && course.Currency != currency {
+			continue
+		}
+		price := course.CoursePrice
+		if unit == PriceMajor {
+			scale := priceScale(course.Currency)
+			price = (price + scale/2) / scale
+		}
+		records = append(records, LegacyCourse{
+			Name:    course.CourseName,
+			Price:   price,
+			Website: s.authors[course.AuthorId].Website,
+			Tags:    course.Tags,
+		})
+	}
+	return records, nil
+}
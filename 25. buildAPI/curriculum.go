@@ -0,0 +1,539 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	errSectionNotFound = errors.New("no section found with given id")
+	errLessonNotFound  = errors.New("no lesson found with given id")
+	errNotEnrolled     = errors.New("learner does not hold a seat in this course")
+)
+
+// Lesson kinds
+const (
+	LessonVideo = "video"
+	LessonText  = "text"
+	LessonQuiz  = "quiz"
+)
+
+// Section is an ordered group of lessons in a course's curriculum
+type Section struct {
+	SectionId string   `json:"sectionid"`
+	Title     string   `json:"title"`
+	Lessons   []Lesson `json:"lessons"`
+}
+
+// Lesson is one step of a course. Which content fields it has depends on
+// its kind: a video has a URL and a duration, a text a body, and a quiz
+// its questions.
+type Lesson struct {
+	LessonId  string         `json:"lessonid"`
+	SectionId string         `json:"sectionid"`
+	Title     string         `json:"title"`
+	Kind      string         `json:"kind"`
+	VideoURL  string         `json:"video_url,omitempty"`
+	Duration  int            `json:"duration_seconds,omitempty"`
+	Body      string         `json:"body,omitempty"`
+	Questions []QuizQuestion `json:"questions,omitempty"`
+}
+
+// QuizQuestion is a multiple choice question; Answer is the index of the
+// right choice
+type QuizQuestion struct {
+	Prompt  string   `json:"prompt"`
+	Choices []string `json:"choices"`
+	Answer  int      `json:"answer"`
+}
+
+// LessonPatch holds the fields a PATCH request sets; nil fields are left
+// alone. Setting the section moves the lesson to the end of that section.
+type LessonPatch struct {
+	SectionId *string         `json:"sectionid"`
+	Title     *string         `json:"title"`
+	Kind      *string         `json:"kind"`
+	VideoURL  *string         `json:"video_url"`
+	Duration  *int            `json:"duration_seconds"`
+	Body      *string         `json:"body"`
+	Questions *[]QuizQuestion `json:"questions"`
+}
+
+func (l *Lesson) validate() error {
+	l.Title = strings.TrimSpace(l.Title)
+	if l.Title == "" {
+		return &ValidationError{"title", "is required"}
+	}
+	switch l.Kind {
+	case LessonVideo:
+		if l.VideoURL == "" {
+			return &ValidationError{"video_url", "is required for video lessons"}
+		}
+		if l.Duration < 0 {
+			return &ValidationError{"duration_seconds", "cannot be negative"}
+		}
+		if l.Body != "" || len(l.Questions) > 0 {
+			return &ValidationError{"kind", "video lessons only have a video_url and duration_seconds"}
+		}
+	case LessonText:
+		if strings.TrimSpace(l.Body) == "" {
+			return &ValidationError{"body", "is required for text lessons"}
+		}
+		if l.VideoURL != "" || l.Duration != 0 || len(l.Questions) > 0 {
+			return &ValidationError{"kind", "text lessons only have a body"}
+		}
+	case LessonQuiz:
+		if len(l.Questions) == 0 {
+			return &ValidationError{"questions", "are required for quizzes"}
+		}
+		for i, q := range l.Questions {
+			if strings.TrimSpace(q.Prompt) == "" || len(q.Choices) < 2 || q.Answer < 0 || q.Answer >= len(q.Choices) {
+				return &ValidationError{fmt.Sprintf("questions[%d]", i), "needs a prompt, at least two choices and the index of the right one"}
+			}
+		}
+		if l.VideoURL != "" || l.Duration != 0 || l.Body != "" {
+			return &ValidationError{"kind", "quizzes only have questions"}
+		}
+	default:
+		return &ValidationError{"kind", "must be video, text or quiz"}
+	}
+	return nil
+}
+
+// Progress is how far a learner has got through a course
+type Progress struct {
+	CourseId       string     `json:"courseid"`
+	LearnerId      string     `json:"learner_id"`
+	Completed      []string   `json:"completed"` // lesson IDs, in curriculum order
+	CompletedCount int        `json:"completed_count"`
+	TotalLessons   int        `json:"total_lessons"`
+	Percent        int        `json:"percent"`
+	LastLessonId   string     `json:"last_lessonid,omitempty"`   // the lesson last completed
+	ResumeLessonId string     `json:"resume_lessonid,omitempty"` // where to carry on; empty when all are done
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+// progress is what the catalog stores per learner; the rest of Progress is
+// worked out from the curriculum when it is read
+type progress struct {
+	completed idSet
+	last      string
+	updatedAt time.Time
+}
+
+type learnerKey struct {
+	courseID, learnerID string
+}
+
+// Curriculum returns the sections of a course with their lessons, in order
+func (s *Catalog) Curriculum(courseID string) ([]Section, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.courses[courseID]; !ok {
+		return nil, errCourseNotFound
+	}
+	sections := make([]Section, len(s.curricula[courseID]))
+	for i, section := range s.curricula[courseID] {
+		sections[i] = section.clone()
+	}
+	return sections, nil
+}
+
+func (section Section) clone() Section {
+	section.Lessons = slices.Clone(section.Lessons)
+	if section.Lessons == nil {
+		section.Lessons = []Lesson{}
+	}
+	return section
+}
+
+// AddSection appends a section to the end of a course's curriculum
+func (s *Catalog) AddSection(courseID, title string) (Section, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return Section{}, &ValidationError{"title", "is required"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.courses[courseID]; !ok {
+		return Section{}, errCourseNotFound
+	}
+	id, err := s.newID()
+	if err != nil {
+		return Section{}, err
+	}
+	section := Section{SectionId: id, Title: title, Lessons: []Lesson{}}
+	s.curricula[courseID] = append(s.curricula[courseID], section)
+	return section, nil
+}
+
+func (s *Catalog) GetSection(courseID, sectionID string) (Section, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, err := s.sectionIndex(courseID, sectionID)
+	if err != nil {
+		return Section{}, err
+	}
+	return s.curricula[courseID][i].clone(), nil
+}
+
+// RenameSection changes the title of a section
+func (s *Catalog) RenameSection(courseID, sectionID, title string) (Section, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return Section{}, &ValidationError{"title", "is required"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.sectionIndex(courseID, sectionID)
+	if err != nil {
+		return Section{}, err
+	}
+	s.curricula[courseID][i].Title = title
+	return s.curricula[courseID][i].clone(), nil
+}
+
+// DeleteSection removes a section and its lessons
+func (s *Catalog) DeleteSection(courseID, sectionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.sectionIndex(courseID, sectionID)
+	if err != nil {
+		return err
+	}
+	for _, lesson := range s.curricula[courseID][i].Lessons {
+		s.forgetLesson(courseID, lesson.LessonId)
+	}
+	s.curricula[courseID] = slices.Delete(s.curricula[courseID], i, i+1)
+	return nil
+}
+
+// ReorderSections puts a course's sections in the given order, which must
+// name each of them exactly once
+func (s *Catalog) ReorderSections(courseID string, order []string) ([]Section, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.courses[courseID]; !ok {
+		return nil, errCourseNotFound
+	}
+	sections := s.curricula[courseID]
+	reordered, err := reorder(sections, order, func(section Section) string { return section.SectionId })
+	if err != nil {
+		return nil, err
+	}
+	s.curricula[courseID] = reordered
+	result := make([]Section, len(reordered))
+	for i, section := range reordered {
+		result[i] = section.clone()
+	}
+	return result, nil
+}
+
+// AddLesson appends a lesson to the end of a section
+func (s *Catalog) AddLesson(courseID, sectionID string, lesson Lesson) (Lesson, error) {
+	if err := lesson.validate(); err != nil {
+		return Lesson{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.sectionIndex(courseID, sectionID)
+	if err != nil {
+		return Lesson{}, err
+	}
+	id, err := s.newID()
+	if err != nil {
+		return Lesson{}, err
+	}
+	lesson.LessonId = id
+	lesson.SectionId = sectionID
+	section := &s.curricula[courseID][i]
+	section.Lessons = append(section.Lessons, lesson)
+	return lesson, nil
+}
+
+func (s *Catalog) GetLesson(courseID, lessonID string) (Lesson, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, j, err := s.lessonIndex(courseID, lessonID)
+	if err != nil {
+		return Lesson{}, err
+	}
+	return s.curricula[courseID][i].Lessons[j], nil
+}
+
+// UpdateLesson applies a patch to a lesson, moving it to the end of
+// another section when the patch names one
+func (s *Catalog) UpdateLesson(courseID, lessonID string, patch LessonPatch) (Lesson, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, j, err := s.lessonIndex(courseID, lessonID)
+	if err != nil {
+		return Lesson{}, err
+	}
+	sections := s.curricula[courseID]
+	lesson := sections[i].Lessons[j]
+	lesson.Questions = slices.Clone(lesson.Questions)
+	if patch.Title != nil {
+		lesson.Title = *patch.Title
+	}
+	if patch.Kind != nil {
+		lesson.Kind = *patch.Kind
+	}
+	if patch.VideoURL != nil {
+		lesson.VideoURL = *patch.VideoURL
+	}
+	if patch.Duration != nil {
+		lesson.Duration = *patch.Duration
+	}
+	if patch.Body != nil {
+		lesson.Body = *patch.Body
+	}
+	if patch.Questions != nil {
+		lesson.Questions = *patch.Questions
+	}
+	if err := lesson.validate(); err != nil {
+		return Lesson{}, err
+	}
+
+	target := i
+	if patch.SectionId != nil && *patch.SectionId != lesson.SectionId {
+		if target, err = s.sectionIndex(courseID, *patch.SectionId); err != nil {
+			return Lesson{}, &ValidationError{"sectionid", "does not name a section of this course"}
+		}
+	}
+	if target == i {
+		sections[i].Lessons[j] = lesson
+		return lesson, nil
+	}
+	sections[i].Lessons = slices.Delete(sections[i].Lessons, j, j+1)
+	lesson.SectionId = sections[target].SectionId
+	sections[target].Lessons = append(sections[target].Lessons, lesson)
+	return lesson, nil
+}
+
+// DeleteLesson removes a lesson; it stops counting towards any learner's
+// progress
+func (s *Catalog) DeleteLesson(courseID, lessonID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, j, err := s.lessonIndex(courseID, lessonID)
+	if err != nil {
+		return err
+	}
+	section := &s.curricula[courseID][i]
+	section.Lessons = slices.Delete(section.Lessons, j, j+1)
+	s.forgetLesson(courseID, lessonID)
+	return nil
+}
+
+// ReorderLessons puts a section's lessons in the given order, which must
+// name each of them exactly once
+func (s *Catalog) ReorderLessons(courseID, sectionID string, order []string) (Section, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.sectionIndex(courseID, sectionID)
+	if err != nil {
+		return Section{}, err
+	}
+	section := &s.curricula[courseID][i]
+	reordered, err := reorder(section.Lessons, order, func(lesson Lesson) string { return lesson.LessonId })
+	if err != nil {
+		return Section{}, err
+	}
+	section.Lessons = reordered
+	return section.clone(), nil
+}
+
+// reorder returns items in the order of their IDs in order, which must be
+// a permutation of them
+func reorder[T any](items []T, order []string, idOf func(T) string) ([]T, error) {
+	if len(order) != len(items) {
+		return nil, &ValidationError{"order", fmt.Sprintf("must list all %d ids exactly once", len(items))}
+	}
+	byID := make(map[string]T, len(items))
+	for _, item := range items {
+		byID[idOf(item)] = item
+	}
+	reordered := make([]T, 0, len(items))
+	for _, id := range order {
+		item, ok := byID[id]
+		if !ok {
+			return nil, &ValidationError{"order", "lists an unknown or repeated id: " + id}
+		}
+		delete(byID, id)
+		reordered = append(reordered, item)
+	}
+	return reordered, nil
+}
+
+// sectionIndex finds a section in a course's curriculum; callers must
+// hold s.mu
+func (s *Catalog) sectionIndex(courseID, sectionID string) (int, error) {
+	if _, ok := s.courses[courseID]; !ok {
+		return 0, errCourseNotFound
+	}
+	i := slices.IndexFunc(s.curricula[courseID], func(section Section) bool { return section.SectionId == sectionID })
+	if i < 0 {
+		return 0, errSectionNotFound
+	}
+	return i, nil
+}
+
+// lessonIndex finds a lesson by its section and its place in it; callers
+// must hold s.mu
+func (s *Catalog) lessonIndex(courseID, lessonID string) (int, int, error) {
+	if _, ok := s.courses[courseID]; !ok {
+		return 0, 0, errCourseNotFound
+	}
+	for i, section := range s.curricula[courseID] {
+		if j := slices.IndexFunc(section.Lessons, func(lesson Lesson) bool { return lesson.LessonId == lessonID }); j >= 0 {
+			return i, j, nil
+		}
+	}
+	return 0, 0, errLessonNotFound
+}
+
+// forgetLesson takes a removed lesson out of every learner's progress;
+// callers must hold s.mu
+func (s *Catalog) forgetLesson(courseID, lessonID string) {
+	for learnerID := range s.learners[courseID] {
+		p := s.progress[learnerKey{courseID, learnerID}]
+		delete(p.completed, lessonID)
+		if p.last == lessonID {
+			p.last = ""
+		}
+	}
+}
+
+// dropCurriculum forgets the curriculum of a course that is being deleted
+// and every learner's progress through it; callers must hold s.mu
+func (s *Catalog) dropCurriculum(courseID string) {
+	for learnerID := range s.learners[courseID] {
+		delete(s.progress, learnerKey{courseID, learnerID})
+	}
+	delete(s.learners, courseID)
+	delete(s.curricula, courseID)
+}
+
+// CompleteLesson records that a learner finished a lesson, or, with done
+// unset, takes that back. Only learners with a seat in the course have
+// progress.
+func (s *Catalog) CompleteLesson(courseID, learnerID, lessonID string, done bool, t time.Time) (Progress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, _, err := s.lessonIndex(courseID, lessonID); err != nil {
+		return Progress{}, err
+	}
+	if !s.isEnrolled(courseID, learnerID) {
+		return Progress{}, errNotEnrolled
+	}
+
+	key := learnerKey{courseID, learnerID}
+	p := s.progress[key]
+	if p == nil {
+		p = &progress{completed: idSet{}}
+		s.progress[key] = p
+		s.learners.add(courseID, learnerID)
+	}
+	if done {
+		p.completed[lessonID] = struct{}{}
+		p.last = lessonID
+	} else {
+		delete(p.completed, lessonID)
+		if p.last == lessonID {
+			p.last = ""
+		}
+	}
+	p.updatedAt = t
+	return s.progressOf(courseID, learnerID), nil
+}
+
+// Progress reports how far a learner with a seat in a course has got
+func (s *Catalog) Progress(courseID, learnerID string) (Progress, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.courses[courseID]; !ok {
+		return Progress{}, errCourseNotFound
+	}
+	if !s.isEnrolled(courseID, learnerID) {
+		return Progress{}, errNotEnrolled
+	}
+	return s.progressOf(courseID, learnerID), nil
+}
+
+// isEnrolled reports whether a learner is enrolled in a course, not just
+// waitlisted; callers must hold s.mu
+func (s *Catalog) isEnrolled(courseID, learnerID string) bool {
+	r := s.rosters[courseID]
+	if r == nil {
+		return false
+	}
+	id, ok := r.active[learnerID]
+	return ok && s.enrollments[id].Status == EnrollmentEnrolled
+}
+
+// progressOf works out a learner's progress against the current
+// curriculum. They resume at the first lesson they have not completed
+// after the one they completed last, or failing that the first they have
+// not completed at all. Callers must hold s.mu.
+func (s *Catalog) progressOf(courseID, learnerID string) Progress {
+	result := Progress{CourseId: courseID, LearnerId: learnerID, Completed: []string{}}
+	p := s.progress[learnerKey{courseID, learnerID}]
+	if p == nil {
+		p = &progress{}
+	} else {
+		result.LastLessonId = p.last
+		updatedAt := p.updatedAt // a copy, as p changes once s.mu is released
+		result.UpdatedAt = &updatedAt
+	}
+
+	firstOpen, nextOpen, seenLast := "", "", false
+	for _, section := range s.curricula[courseID] {
+		for _, lesson := range section.Lessons {
+			result.TotalLessons++
+			switch {
+			case p.completed.has(lesson.LessonId):
+				result.Completed = append(result.Completed, lesson.LessonId)
+			case firstOpen == "":
+				firstOpen = lesson.LessonId
+				fallthrough
+			default:
+				if seenLast && nextOpen == "" {
+					nextOpen = lesson.LessonId
+				}
+			}
+			if lesson.LessonId == p.last {
+				seenLast = true
+			}
+		}
+	}
+
+	result.CompletedCount = len(result.Completed)
+	if result.TotalLessons > 0 {
+		result.Percent = result.CompletedCount * 100 / result.TotalLessons
+	}
+	result.ResumeLessonId = firstOpen
+	if nextOpen != "" {
+		result.ResumeLessonId = nextOpen
+	}
+	return result
+}
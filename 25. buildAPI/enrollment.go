@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	errAlreadyEnrolled    = errors.New("student is already enrolled or waitlisted for this course")
+	errEnrollmentNotFound = errors.New("student is not enrolled or waitlisted for this course")
+)
+
+// Enrollment statuses
+const (
+	EnrollmentEnrolled   = "enrolled"
+	EnrollmentWaitlisted = "waitlisted"
+	EnrollmentWithdrawn  = "withdrawn"
+)
+
+// Enrollment is a student's place in a course, or in the queue for it.
+// The price is fixed when the student signs up, coupon included, even if
+// they only get a seat later from the waitlist.
+type Enrollment struct {
+	EnrollmentId string     `json:"enrollmentid"`
+	CourseId     string     `json:"courseid"`
+	StudentId    string     `json:"student_id"`
+	Status       string     `json:"status"`
+	Position     int        `json:"position,omitempty"` // place on the waitlist, from 1
+	Coupon       string     `json:"coupon,omitempty"`
+	Price        int        `json:"price"`
+	Currency     string     `json:"currency"`
+	CreatedAt    time.Time  `json:"created_at"`
+	EnrolledAt   *time.Time `json:"enrolled_at,omitempty"`
+	WithdrawnAt  *time.Time `json:"withdrawn_at,omitempty"`
+}
+
+// roster holds the active enrollments of one course, seat holders and
+// waitlist each in the order they got there
+type roster struct {
+	enrolled []string          // enrollment IDs
+	waitlist []string          // enrollment IDs, head first
+	active   map[string]string // student ID to enrollment ID
+}
+
+func (s *Catalog) rosterOf(courseID string) *roster {
+	r := s.rosters[courseID]
+	if r == nil {
+		r = &roster{active: map[string]string{}}
+		s.rosters[courseID] = r
+	}
+	return r
+}
+
+// activeFor returns the ID of a student's active enrollment; it works on
+// a nil roster, for courses nobody has signed up for
+func (r *roster) activeFor(studentID string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	id, ok := r.active[studentID]
+	return id, ok
+}
+
+// hasSeat reports whether the course has room for one more student
+func (r *roster) hasSeat(course Course) bool {
+	return course.Capacity == 0 || len(r.enrolled) < course.Capacity
+}
+
+// Withdrawal is the result of a student leaving a course: their
+// enrollment, and whoever got their seat from the waitlist
+type Withdrawal struct {
+	Withdrawn Enrollment  `json:"withdrawn"`
+	Promoted  *Enrollment `json:"promoted,omitempty"`
+}
+
+// Enroll gives a student a seat on the course, or a place on its waitlist
+// when the course is full. Seats are counted under the catalog lock, so
+// concurrent sign-ups can never take the course over capacity. A coupon
+// code, if given, is redeemed straight away, so the price it gives holds
+// on the waitlist too; the use is given back if the student leaves the
+// waitlist without ever getting a seat.
+func (s *Catalog) Enroll(courseID, studentID, code string, t time.Time) (Enrollment, error) {
+	studentID = strings.TrimSpace(studentID)
+	if studentID == "" {
+		return Enrollment{}, &ValidationError{"student_id", "is required"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	course, ok := s.courses[courseID]
+	if !ok {
+		return Enrollment{}, errCourseNotFound
+	}
+	r := s.rosterOf(courseID)
+	if _, ok := r.active[studentID]; ok {
+		return Enrollment{}, errAlreadyEnrolled
+	}
+
+	// The ID comes first: nothing may fail once the coupon is redeemed
+	id, err := s.newID()
+	if err != nil {
+		return Enrollment{}, err
+	}
+	quote := quoteCourse(course, nil, t)
+	if code != "" {
+		if quote, err = s.redeem(course, code, t); err != nil {
+			return Enrollment{}, err
+		}
+	}
+
+	enrollment := Enrollment{
+		EnrollmentId: id,
+		CourseId:     courseID,
+		StudentId:    studentID,
+		Coupon:       strings.ToUpper(code),
+		Price:        quote.Total,
+		Currency:     quote.Currency,
+		CreatedAt:    t,
+	}
+	if r.hasSeat(course) {
+		enrollment.Status = EnrollmentEnrolled
+		enrollment.EnrolledAt = &t
+		r.enrolled = append(r.enrolled, id)
+	} else {
+		enrollment.Status = EnrollmentWaitlisted
+		r.waitlist = append(r.waitlist, id)
+	}
+	r.active[studentID] = id
+	s.enrollments[id] = enrollment
+	s.byStudent.add(studentID, id)
+	return s.withPosition(enrollment), nil
+}
+
+// Withdraw takes a student off the course, from their seat or from the
+// waitlist. A freed seat goes to the head of the waitlist, and a coupon
+// redeemed by a student who never got a seat can be used again.
+func (s *Catalog) Withdraw(courseID, studentID string, t time.Time) (Withdrawal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.courses[courseID]; !ok {
+		return Withdrawal{}, errCourseNotFound
+	}
+	r := s.rosterOf(courseID)
+	id, ok := r.active[studentID]
+	if !ok {
+		return Withdrawal{}, errEnrollmentNotFound
+	}
+
+	enrollment := s.enrollments[id]
+	if enrollment.Status == EnrollmentEnrolled {
+		r.enrolled = slices.DeleteFunc(r.enrolled, func(e string) bool { return e == id })
+	} else {
+		r.waitlist = slices.DeleteFunc(r.waitlist, func(e string) bool { return e == id })
+		s.releaseCoupon(enrollment)
+	}
+	delete(r.active, studentID)
+	enrollment.Status = EnrollmentWithdrawn
+	enrollment.WithdrawnAt = &t
+	s.enrollments[id] = enrollment
+
+	withdrawal := Withdrawal{Withdrawn: enrollment}
+	if promoted := s.fillSeats(courseID); len(promoted) > 0 {
+		withdrawal.Promoted = &promoted[0]
+	}
+	return withdrawal, nil
+}
+
+// GetEnrollment returns a student's active enrollment in a course
+func (s *Catalog) GetEnrollment(courseID, studentID string) (Enrollment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.courses[courseID]; !ok {
+		return Enrollment{}, errCourseNotFound
+	}
+	id, ok := s.rosters[courseID].activeFor(studentID)
+	if !ok {
+		return Enrollment{}, errEnrollmentNotFound
+	}
+	return s.withPosition(s.enrollments[id]), nil
+}
+
+// fillSeats moves students from the head of the waitlist into free seats,
+// after a withdrawal or a capacity increase, and returns who moved.
+// Callers must hold s.mu.
+func (s *Catalog) fillSeats(courseID string) []Enrollment {
+	r := s.rosters[courseID]
+	if r == nil {
+		return nil
+	}
+	course := s.courses[courseID]
+	var promoted []Enrollment
+	for len(r.waitlist) > 0 && r.hasSeat(course) {
+		id := r.waitlist[0]
+		r.waitlist = r.waitlist[1:]
+		r.enrolled = append(r.enrolled, id)
+
+		now := time.Now().UTC()
+		enrollment := s.enrollments[id]
+		enrollment.Status = EnrollmentEnrolled
+		enrollment.EnrolledAt = &now
+		s.enrollments[id] = enrollment
+		promoted = append(promoted, enrollment)
+	}
+	return promoted
+}
+
+// releaseCoupon gives back the coupon use of an enrollment that never
+// held a seat, unless the coupon has since been deleted; callers must hold
+// s.mu
+func (s *Catalog) releaseCoupon(enrollment Enrollment) {
+	coupon, ok := s.coupons[enrollment.Coupon]
+	if enrollment.Coupon == "" || !ok || coupon.Uses == 0 {
+		return
+	}
+	coupon.Uses--
+	s.coupons[coupon.Code] = coupon
+}
+
+// dropEnrollments forgets every enrollment in a course that is being
+// deleted; callers must hold s.mu
+func (s *Catalog) dropEnrollments(courseID string) {
+	for id, enrollment := range s.enrollments {
+		if enrollment.CourseId == courseID {
+			if enrollment.Status == EnrollmentWaitlisted {
+				s.releaseCoupon(enrollment)
+			}
+			s.byStudent.remove(enrollment.StudentId, id)
+			delete(s.enrollments, id)
+		}
+	}
+	delete(s.rosters, courseID)
+}
+
+// CourseEnrollments lists the active enrollments of a course, seat holders
+// first and then the waitlist in order; status narrows it to one of them
+func (s *Catalog) CourseEnrollments(courseID, status string) ([]Enrollment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.courses[courseID]; !ok {
+		return nil, errCourseNotFound
+	}
+	list := []Enrollment{}
+	r := s.rosters[courseID]
+	if r == nil {
+		return list, nil
+	}
+	if status == "" || status == EnrollmentEnrolled {
+		for _, id := range r.enrolled {
+			list = append(list, s.enrollments[id])
+		}
+	}
+	if status == "" || status == EnrollmentWaitlisted {
+		for i, id := range r.waitlist {
+			enrollment := s.enrollments[id]
+			enrollment.Position = i + 1
+			list = append(list, enrollment)
+		}
+	}
+	return list, nil
+}
+
+// StudentEnrollments lists every enrollment of a student, withdrawn ones
+// included, oldest first
+func (s *Catalog) StudentEnrollments(studentID string) []Enrollment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []Enrollment{}
+	for id := range s.byStudent[studentID] {
+		list = append(list, s.withPosition(s.enrollments[id]))
+	}
+	slices.SortFunc(list, func(a, b Enrollment) int { return strings.Compare(a.EnrollmentId, b.EnrollmentId) })
+	return list
+}
+
+// withPosition fills in where a waitlisted enrollment stands in the queue;
+// callers must hold s.mu
+func (s *Catalog) withPosition(enrollment Enrollment) Enrollment {
+	if enrollment.Status == EnrollmentWaitlisted {
+		enrollment.Position = slices.Index(s.rosters[enrollment.CourseId].waitlist, enrollment.EnrollmentId) + 1
+	}
+	return enrollment
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCouponMaxUsesHoldsUnderConcurrentEnrollments(t *testing.T) {
+	c := NewCatalog(&ULIDGenerator{})
+	course, err := c.Add(Course{CourseName: "Go", CoursePrice: 1000, Currency: "USD", Capacity: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AddCoupon(Coupon{Code: "LIMITED", Kind: DiscountPercent, Percent: 50, MaxUses: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	const students = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	discounted := 0
+	for i := range students {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			enrollment, err := c.Enroll(course.CourseId, fmt.Sprint("student-", i), "limited", time.Now())
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if enrollment.Price != 500 {
+				t.Errorf("enrolled with the coupon at %d, want 500", enrollment.Price)
+			}
+			discounted++
+		}()
+	}
+	wg.Wait()
+
+	if discounted != 3 {
+		t.Errorf("%d students enrolled with the coupon, max_uses is 3", discounted)
+	}
+	coupon, err := c.GetCoupon("LIMITED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coupon.Uses != 3 {
+		t.Errorf("coupon has %d uses, want 3", coupon.Uses)
+	}
+}
+
+func TestEnrollWithoutAnIDKeepsTheCoupon(t *testing.T) {
+	c := NewCatalog(sameID{})
+	course, err := c.Add(Course{CourseName: "Go", CoursePrice: 1000, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AddCoupon(Coupon{Code: "ONCE", Kind: DiscountPercent, Percent: 50, MaxUses: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Enroll(course.CourseId, "ada", "ONCE", time.Now()); !errors.Is(err, errIDCollision) {
+		t.Fatalf("enroll gave %v, want the ID collision", err)
+	}
+	if coupon, _ := c.GetCoupon("ONCE"); coupon.Uses != 0 {
+		t.Errorf("a failed enrollment used the coupon: %d uses", coupon.Uses)
+	}
+}
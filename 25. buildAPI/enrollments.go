@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// enrollment controllers - file
+
+func getCourseEnrollments(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get course enrollments")
+	w.Header().Set("Content-Type", "application/json")
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != EnrollmentEnrolled && status != EnrollmentWaitlisted {
+		writeError(w, http.StatusBadRequest, "status must be enrolled or waitlisted")
+		return
+	}
+	enrollments, err := catalog.CourseEnrollments(mux.Vars(r)["id"], status)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(enrollments)
+}
+
+// createOneEnrollment signs a student up for a course; the response says
+// whether they got a seat or a place on the waitlist
+func createOneEnrollment(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Create one enrollment")
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		StudentId string `json:"student_id"`
+		Coupon    string `json:"coupon"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	enrollment, err := catalog.Enroll(mux.Vars(r)["id"], body.StudentId, body.Coupon, time.Now().UTC())
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	writeCreated(w, "/courses/"+enrollment.CourseId+"/enrollments/"+url.PathEscape(enrollment.StudentId))
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func getOneEnrollment(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get one enrollment")
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	enrollment, err := catalog.GetEnrollment(vars["id"], vars["student"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func deleteOneEnrollment(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Withdraw one enrollment")
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	withdrawal, err := catalog.Withdraw(vars["id"], vars["student"], time.Now().UTC())
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(withdrawal)
+}
+
+func getStudentEnrollments(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get student enrollments")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(catalog.StudentEnrollments(mux.Vars(r)["student"]))
+}
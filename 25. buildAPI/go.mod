@@ -2,4 +2,4 @@ module buildAPI
 
 go 1.23.5
 
-require github.com/gorilla/mux v1.8.1 // indirect
+require github.com/gorilla/mux v1.8.1
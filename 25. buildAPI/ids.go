@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IDGenerator hands out course IDs. Both implementations start with a
+// millisecond timestamp and stay increasing within a millisecond, so IDs
+// sort in creation order as plain strings.
+type IDGenerator interface {
+	NewID() string
+}
+
+func newIDGenerator(format string) (IDGenerator, error) {
+	switch format {
+	case "uuidv7":
+		return &UUIDv7Generator{}, nil
+	case "ulid":
+		return &ULIDGenerator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown id format %q, use uuidv7 or ulid", format)
+	}
+}
+
+// UUIDv7Generator makes RFC 9562 version 7 UUIDs. The 12 bit rand_a field
+// is a counter within the millisecond (method 1 of section 6.2); when it
+// runs out the timestamp is moved on by a millisecond.
+type UUIDv7Generator struct {
+	mu      sync.Mutex
+	lastMS  int64
+	counter uint16
+}
+
+func (g *UUIDv7Generator) NewID() string {
+	g.mu.Lock()
+	ms := time.Now().UnixMilli()
+	if ms <= g.lastMS {
+		ms = g.lastMS
+		g.counter++
+		if g.counter > 0xfff {
+			ms++
+			g.counter = 0
+		}
+	} else {
+		g.counter = 0
+	}
+	g.lastMS = ms
+	counter := g.counter
+	g.mu.Unlock()
+
+	var u [16]byte
+	binary.BigEndian.PutUint64(u[0:8], uint64(ms)<<16)
+	rand.Read(u[8:])
+	u[6] = 0x70 | byte(counter>>8) // version 7
+	u[7] = byte(counter)
+	u[8] = 0x80 | u[8]&0x3f // variant 10
+
+	h := hex.EncodeToString(u[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDGenerator makes monotonic ULIDs: 48 bits of milliseconds and 80
+// random bits, which are incremented instead of redrawn within a millisecond
+type ULIDGenerator struct {
+	mu      sync.Mutex
+	lastMS  int64
+	entropy [10]byte
+}
+
+func (g *ULIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := time.Now().UnixMilli()
+	if ms <= g.lastMS {
+		ms = g.lastMS
+		if !increment(g.entropy[:]) {
+			ms++
+			rand.Read(g.entropy[:])
+		}
+	} else {
+		rand.Read(g.entropy[:])
+	}
+	g.lastMS = ms
+
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[0:8], uint64(ms)<<16)
+	copy(id[6:], g.entropy[:])
+	return encodeULID(id)
+}
+
+// increment adds one to a big-endian number, reporting false on overflow
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeULID writes the 128 bits as 26 Crockford base32 characters, the
+// first of which carries only 3 bits
+func encodeULID(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[0:8])
+	lo := binary.BigEndian.Uint64(id[8:16])
+
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
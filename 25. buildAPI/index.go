@@ -0,0 +1,257 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// The catalog keeps these indexes up to date on every write so that
+// searches, filters and sorts look courses up instead of scanning them all.
+
+type idSet map[string]struct{}
+
+func (s idSet) has(id string) bool {
+	_, ok := s[id]
+	return ok
+}
+
+// intersect returns the IDs in both sets; a nil set stands for every ID
+func intersect(a, b idSet) idSet {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	both := idSet{}
+	for id := range a {
+		if b.has(id) {
+			both[id] = struct{}{}
+		}
+	}
+	return both
+}
+
+// setIndex maps a key, such as an author ID or a tag, to the courses that have it
+type setIndex map[string]idSet
+
+func (x setIndex) add(key, id string) {
+	if x[key] == nil {
+		x[key] = idSet{}
+	}
+	x[key][id] = struct{}{}
+}
+
+func (x setIndex) remove(key, id string) {
+	delete(x[key], id)
+	if len(x[key]) == 0 {
+		delete(x, key)
+	}
+}
+
+type indexEntry[K cmp.Ordered] struct {
+	key K
+	id  string
+}
+
+func compareEntries[K cmp.Ordered](a, b indexEntry[K]) int {
+	if c := cmp.Compare(a.key, b.key); c != 0 {
+		return c
+	}
+	return strings.Compare(a.id, b.id)
+}
+
+// sortedIndex keeps course IDs ordered by a key, ties broken by ID
+type sortedIndex[K cmp.Ordered] []indexEntry[K]
+
+func (x *sortedIndex[K]) insert(key K, id string) {
+	entry := indexEntry[K]{key, id}
+	i, _ := slices.BinarySearchFunc(*x, entry, compareEntries[K])
+	*x = slices.Insert(*x, i, entry)
+}
+
+func (x *sortedIndex[K]) remove(key K, id string) {
+	if i, found := slices.BinarySearchFunc(*x, indexEntry[K]{key, id}, compareEntries[K]); found {
+		*x = slices.Delete(*x, i, i+1)
+	}
+}
+
+// between returns the entries with lo <= key <= hi
+func (x sortedIndex[K]) between(lo, hi K) sortedIndex[K] {
+	start, _ := slices.BinarySearchFunc(x, lo, func(e indexEntry[K], k K) int { return cmp.Compare(e.key, k) })
+	end, _ := slices.BinarySearchFunc(x, hi, func(e indexEntry[K], k K) int {
+		if e.key <= k {
+			return -1
+		}
+		return 1
+	})
+	return x[start:end]
+}
+
+func (x sortedIndex[K]) ids() idSet {
+	set := make(idSet, len(x))
+	for _, entry := range x {
+		set[entry.id] = struct{}{}
+	}
+	return set
+}
+
+// courseIndex holds every index of the catalog's courses
+type courseIndex struct {
+	byCreated  sortedIndex[string] // keyed by ID, which is time ordered
+	byPrice    sortedIndex[int]
+	byName     sortedIndex[string]
+	byRating   sortedIndex[float64] // average of approved reviews
+	byAuthor   setIndex
+	byCurrency setIndex
+	byTag      setIndex
+	byWord     setIndex
+	words      []string // sorted keys of byWord, for prefix lookups
+}
+
+func newCourseIndex() *courseIndex {
+	return &courseIndex{byAuthor: setIndex{}, byCurrency: setIndex{}, byTag: setIndex{}, byWord: setIndex{}}
+}
+
+func (x *courseIndex) add(c Course) {
+	x.byCreated.insert(c.CourseId, c.CourseId)
+	x.byPrice.insert(c.CoursePrice, c.CourseId)
+	x.byName.insert(strings.ToLower(c.CourseName), c.CourseId)
+	x.byRating.insert(c.Rating.Average, c.CourseId)
+	if c.AuthorId != "" {
+		x.byAuthor.add(c.AuthorId, c.CourseId)
+	}
+	x.byCurrency.add(c.Currency, c.CourseId)
+	for _, tag := range c.Tags {
+		x.byTag.add(tag, c.CourseId)
+	}
+	for _, word := range searchWords(c.CourseName) {
+		if x.byWord[word] == nil {
+			i, _ := slices.BinarySearch(x.words, word)
+			x.words = slices.Insert(x.words, i, word)
+		}
+		x.byWord.add(word, c.CourseId)
+	}
+}
+
+func (x *courseIndex) remove(c Course) {
+	x.byCreated.remove(c.CourseId, c.CourseId)
+	x.byPrice.remove(c.CoursePrice, c.CourseId)
+	x.byName.remove(strings.ToLower(c.CourseName), c.CourseId)
+	x.byRating.remove(c.Rating.Average, c.CourseId)
+	x.byAuthor.remove(c.AuthorId, c.CourseId)
+	x.byCurrency.remove(c.Currency, c.CourseId)
+	for _, tag := range c.Tags {
+		x.byTag.remove(tag, c.CourseId)
+	}
+	for _, word := range searchWords(c.CourseName) {
+		x.byWord.remove(word, c.CourseId)
+		if x.byWord[word] == nil {
+			if i, found := slices.BinarySearch(x.words, word); found {
+				x.words = slices.Delete(x.words, i, i+1)
+			}
+		}
+	}
+}
+
+// matchText returns the courses whose name has a word starting with each
+// word of the query
+func (x *courseIndex) matchText(query string) idSet {
+	var matches idSet
+	for _, term := range searchWords(query) {
+		termMatches := idSet{}
+		i, _ := slices.BinarySearch(x.words, term)
+		for ; i < len(x.words) && strings.HasPrefix(x.words[i], term); i++ {
+			for id := range x.byWord[x.words[i]] {
+				termMatches[id] = struct{}{}
+			}
+		}
+		matches = intersect(matches, termMatches)
+		if len(matches) == 0 {
+			break
+		}
+	}
+	if matches == nil {
+		return idSet{}
+	}
+	return matches
+}
+
+// searchWords splits text into distinct lower-case words
+func searchWords(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	slices.Sort(words)
+	return slices.Compact(words)
+}
+
+// normalizeTags lower-cases, trims and de-duplicates tags
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+func (s idSet) orEmpty() idSet {
+	if s == nil {
+		return idSet{}
+	}
+	return s
+}
+
+// page returns count IDs from position start of the index order, or of
+// the reverse order when desc is set, keeping only IDs in set (nil keeps
+// all), and how many IDs there are in total. Without a set the page is
+// sliced straight out of the index; a set much smaller than the index is
+// sorted on its own, using keyOf, instead of walking the index.
+func (x sortedIndex[K]) page(set idSet, keyOf func(id string) K, desc bool, start, count int) ([]string, int) {
+	var ordered []string
+	switch {
+	case set == nil:
+		total := len(x)
+		start = min(start, total)
+		end := min(start+count, total)
+		ids := make([]string, 0, end-start)
+		for i := start; i < end; i++ {
+			if desc {
+				ids = append(ids, x[total-1-i].id)
+			} else {
+				ids = append(ids, x[i].id)
+			}
+		}
+		return ids, total
+	case len(set)*8 < len(x):
+		entries := make(sortedIndex[K], 0, len(set))
+		for id := range set {
+			entries = append(entries, indexEntry[K]{keyOf(id), id})
+		}
+		slices.SortFunc(entries, compareEntries[K])
+		for _, entry := range entries {
+			ordered = append(ordered, entry.id)
+		}
+	default:
+		for _, entry := range x {
+			if set.has(entry.id) {
+				ordered = append(ordered, entry.id)
+			}
+		}
+	}
+
+	if desc {
+		slices.Reverse(ordered)
+	}
+	total := len(ordered)
+	start = min(start, total)
+	return ordered[start:min(start+count, total)], total
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// curriculum and progress controllers - file
+
+func getCurriculum(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get course curriculum")
+	w.Header().Set("Content-Type", "application/json")
+
+	sections, err := catalog.Curriculum(mux.Vars(r)["id"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(sections)
+}
+
+func createOneSection(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Create one section")
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Title string `json:"title"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	courseID := mux.Vars(r)["id"]
+	section, err := catalog.AddSection(courseID, body.Title)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	writeCreated(w, "/courses/"+courseID+"/sections/"+section.SectionId)
+	json.NewEncoder(w).Encode(section)
+}
+
+func getOneSection(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get one section")
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	section, err := catalog.GetSection(vars["id"], vars["section"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(section)
+}
+
+func updateOneSection(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Update one section")
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Title string `json:"title"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	section, err := catalog.RenameSection(vars["id"], vars["section"], body.Title)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(section)
+}
+
+func deleteOneSection(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Delete one section")
+
+	vars := mux.Vars(r)
+	if err := catalog.DeleteSection(vars["id"], vars["section"]); err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reorderSections takes {"order": [sectionid, ...]} naming every section
+func reorderSections(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Reorder sections")
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Order []string `json:"order"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	sections, err := catalog.ReorderSections(mux.Vars(r)["id"], body.Order)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(sections)
+}
+
+func createOneLesson(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Create one lesson")
+	w.Header().Set("Content-Type", "application/json")
+
+	var lesson Lesson
+	if !decodeJSON(w, r, &lesson) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	lesson, err := catalog.AddLesson(vars["id"], vars["section"], lesson)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	writeCreated(w, "/courses/"+vars["id"]+"/lessons/"+lesson.LessonId)
+	json.NewEncoder(w).Encode(lesson)
+}
+
+// reorderLessons takes {"order": [lessonid, ...]} naming every lesson of
+// the section
+func reorderLessons(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Reorder lessons")
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Order []string `json:"order"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	section, err := catalog.ReorderLessons(vars["id"], vars["section"], body.Order)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(section)
+}
+
+func getOneLesson(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get one lesson")
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	lesson, err := catalog.GetLesson(vars["id"], vars["lesson"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(lesson)
+}
+
+func patchOneLesson(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Patch one lesson")
+	w.Header().Set("Content-Type", "application/json")
+
+	var patch LessonPatch
+	if !decodeJSON(w, r, &patch) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	lesson, err := catalog.UpdateLesson(vars["id"], vars["lesson"], patch)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(lesson)
+}
+
+func deleteOneLesson(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Delete one lesson")
+
+	vars := mux.Vars(r)
+	if err := catalog.DeleteLesson(vars["id"], vars["lesson"]); err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getProgress reports a learner's percent complete and where to resume
+func getProgress(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get learner progress")
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	progress, err := catalog.Progress(vars["id"], vars["learner"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(progress)
+}
+
+// completeLesson marks a lesson complete with PUT and not complete with
+// DELETE; both answer with the learner's progress
+func completeLesson(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Complete one lesson")
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	done := r.Method == http.MethodPut
+	progress, err := catalog.CompleteLesson(vars["id"], vars["learner"], vars["lesson"], done, time.Now().UTC())
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(progress)
+}
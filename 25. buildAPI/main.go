@@ -2,10 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"io"
+	"math"
+	"mime"
 	"net/http"
+	"os"
+	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -13,28 +19,269 @@ import (
 
 // Model for courses - file
 type Course struct {
-	CourseId    string  `json:"courseid"`
-	CourseName  string  `json:"coursename"`
-	CoursePrice int     `json:"price"`
-	Author      *Author `json:"author"`
+	CourseId    string     `json:"courseid"`
+	CourseName  string     `json:"coursename"`
+	CoursePrice int        `json:"price"` // in minor units of Currency
+	Currency    string     `json:"currency"`
+	Discounts   []Discount `json:"discounts,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Capacity    int        `json:"capacity"` // seats; 0 means unlimited
+	Rating      Rating     `json:"rating"`   // kept by the catalog from approved reviews
+	AuthorId    string     `json:"authorid,omitempty"`
+	Author      *Author    `json:"author,omitempty"` // only filled in with ?expand=author
+	CreatedAt   time.Time  `json:"created_at"`
 }
 
 type Author struct {
+	AuthorId string `json:"authorid"`
 	Fullname string `json:"fullname"`
 	Website  string `json:"website"`
 }
 
-// fake DB
-var courses []Course
+// CoursePatch holds the fields a PATCH request sets; nil fields are left alone
+type CoursePatch struct {
+	CourseName  *string     `json:"coursename"`
+	CoursePrice *int        `json:"price"`
+	Currency    *string     `json:"currency"`
+	Discounts   *[]Discount `json:"discounts"`
+	Tags        *[]string   `json:"tags"`
+	Capacity    *int        `json:"capacity"`
+	AuthorId    *string     `json:"authorid"`
+	Author      *Author     `json:"author"` // rejected, authors are referenced by ID
+}
+
+// AuthorPatch holds the fields a PATCH request sets; nil fields are left alone
+type AuthorPatch struct {
+	Fullname *string `json:"fullname"`
+	Website  *string `json:"website"`
+}
 
 // middleware, helper - file
 func (c *Course) IsEmpty() bool {
 	return c.CourseName == ""
 }
 
+func (a *Author) IsEmpty() bool {
+	return a.Fullname == ""
+}
+
+// expandAuthor reports whether the request asked for ?expand=author
+func expandAuthor(r *http.Request) bool {
+	for _, field := range strings.Split(r.URL.Query().Get("expand"), ",") {
+		if strings.TrimSpace(field) == "author" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCourses encodes a list of courses, inlining their authors when asked to
+func writeCourses(w http.ResponseWriter, r *http.Request, courses []Course) {
+	if courses == nil {
+		courses = []Course{}
+	}
+	if expandAuthor(r) {
+		catalog.ExpandAuthors(courses)
+	}
+	json.NewEncoder(w).Encode(courses)
+}
+
+// writeCourse encodes one course, inlining its author when asked to
+func writeCourse(w http.ResponseWriter, r *http.Request, course Course) {
+	if expandAuthor(r) {
+		expanded := []Course{course}
+		catalog.ExpandAuthors(expanded)
+		course = expanded[0]
+	}
+	json.NewEncoder(w).Encode(course)
+}
+
+// APIError is the body of every error response, wrapped as {"error": ...}.
+// Code is the status text in snake case; Field names the offending field
+// of the request, when there is one.
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+// writeError encodes message with the given status
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeFieldError(w, status, "", message)
+}
+
+// writeFieldError encodes message about one field of the request
+func writeFieldError(w http.ResponseWriter, status int, field, message string) {
+	code := strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error APIError `json:"error"`
+	}{APIError{status, code, message, field}})
+}
+
+// writeCreated answers 201 with the URL of the new resource
+func writeCreated(w http.ResponseWriter, location string) {
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
+}
+
+const maxBodyBytes = 1 << 20
+
+// decodeJSON decodes the request body into v, strictly: the body must be
+// JSON by its Content-Type, hold exactly one value with no fields v does
+// not have, and be at most maxBodyBytes long. On failure it writes the
+// error response and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	return decodeJSONLimit(w, r, v, maxBodyBytes)
+}
+
+// decodeJSONLimit is decodeJSON for bodies of up to limit bytes
+func decodeJSONLimit(w http.ResponseWriter, r *http.Request, v any, limit int64) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		writeError(w, http.StatusBadRequest, "Please send some data")
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(v)
+	if err == nil {
+		if _, err = decoder.Token(); errors.Is(err, io.EOF) {
+			return true
+		}
+		if err == nil {
+			err = errTrailingData
+		}
+	}
+
+	var tooLarge *http.MaxBytesError
+	var wrongType *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body is larger than %d bytes", limit))
+	case errors.Is(err, io.EOF):
+		writeError(w, http.StatusBadRequest, "Please send some data")
+	case errors.As(err, &wrongType):
+		writeFieldError(w, http.StatusBadRequest, wrongType.Field, "must be a JSON "+jsonKind(wrongType.Type.Kind()))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeFieldError(w, http.StatusBadRequest, field, "is not a known field")
+	default:
+		writeError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+	}
+	return false
+}
+
+var errTrailingData = errors.New("body must hold a single JSON value")
+
+// jsonKind names the JSON type a Go kind decodes from
+func jsonKind(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return "number"
+	}
+}
+
 func main() {
+	// gen-cert, and import and export, which are client commands for a
+	// running server
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	fmt.Println("Welcome to build api in golang")
 
+	cfg, err := parseServerFlags(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(2)
+	}
+
+	ids, err := newIDGenerator(cfg.IDFormat)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(2)
+	}
+	catalog = NewCatalog(ids)
+	seedCatalog(catalog)
+
+	if err := serve(cfg, newRouter()); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// routing
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/", serveHome).Methods("GET")
+	r.HandleFunc("/courses", getAllCourses).Methods("GET")
+	r.HandleFunc("/courses", createOneCourse).Methods("POST")
+	r.HandleFunc("/courses/{id}", getOneCourse).Methods("GET")
+	r.HandleFunc("/courses/{id}", updateOneCourse).Methods("PUT")
+	r.HandleFunc("/courses/{id}", patchOneCourse).Methods("PATCH")
+	r.HandleFunc("/courses/{id}", deleteOneCourse).Methods("DELETE")
+	r.HandleFunc("/courses/{id}/quote", getCourseQuote).Methods("GET")
+	r.HandleFunc("/courses/{id}/enrollments", getCourseEnrollments).Methods("GET")
+	r.HandleFunc("/courses/{id}/enrollments", createOneEnrollment).Methods("POST")
+	r.HandleFunc("/courses/{id}/enrollments/{student}", getOneEnrollment).Methods("GET")
+	r.HandleFunc("/courses/{id}/enrollments/{student}", deleteOneEnrollment).Methods("DELETE")
+	r.HandleFunc("/courses/{id}/reviews", getCourseReviews).Methods("GET")
+	r.HandleFunc("/courses/{id}/reviews", createOneReview).Methods("POST")
+	r.HandleFunc("/courses/{id}/reviews/{user}", getOneReview).Methods("GET")
+	r.HandleFunc("/courses/{id}/reviews/{user}", updateOneReview).Methods("PUT")
+	r.HandleFunc("/courses/{id}/reviews/{user}", deleteOneReview).Methods("DELETE")
+	r.HandleFunc("/courses/{id}/reviews/{user}/status", moderateOneReview).Methods("PUT")
+	r.HandleFunc("/courses/{id}/curriculum", getCurriculum).Methods("GET")
+	r.HandleFunc("/courses/{id}/sections", createOneSection).Methods("POST")
+	r.HandleFunc("/courses/{id}/sections/order", reorderSections).Methods("PUT")
+	r.HandleFunc("/courses/{id}/sections/{section}", getOneSection).Methods("GET")
+	r.HandleFunc("/courses/{id}/sections/{section}", updateOneSection).Methods("PATCH")
+	r.HandleFunc("/courses/{id}/sections/{section}", deleteOneSection).Methods("DELETE")
+	r.HandleFunc("/courses/{id}/sections/{section}/lessons", createOneLesson).Methods("POST")
+	r.HandleFunc("/courses/{id}/sections/{section}/lessons/order", reorderLessons).Methods("PUT")
+	r.HandleFunc("/courses/{id}/lessons/{lesson}", getOneLesson).Methods("GET")
+	r.HandleFunc("/courses/{id}/lessons/{lesson}", patchOneLesson).Methods("PATCH")
+	r.HandleFunc("/courses/{id}/lessons/{lesson}", deleteOneLesson).Methods("DELETE")
+	r.HandleFunc("/courses/{id}/progress/{learner}", getProgress).Methods("GET")
+	r.HandleFunc("/courses/{id}/progress/{learner}/lessons/{lesson}", completeLesson).Methods("PUT", "DELETE")
+	r.HandleFunc("/students/{student}/enrollments", getStudentEnrollments).Methods("GET")
+	r.HandleFunc("/coupons", getAllCoupons).Methods("GET")
+	r.HandleFunc("/coupons", createOneCoupon).Methods("POST")
+	r.HandleFunc("/coupons/{code}", getOneCoupon).Methods("GET")
+	r.HandleFunc("/coupons/{code}", deleteOneCoupon).Methods("DELETE")
+	r.HandleFunc("/import", importCourses).Methods("POST")
+	r.HandleFunc("/export", exportCourses).Methods("GET")
+	r.HandleFunc("/authors", getAllAuthors).Methods("GET")
+	r.HandleFunc("/authors", createOneAuthor).Methods("POST")
+	r.HandleFunc("/authors/{id}", getOneAuthor).Methods("GET")
+	r.HandleFunc("/authors/{id}", updateOneAuthor).Methods("PUT")
+	r.HandleFunc("/authors/{id}", patchOneAuthor).Methods("PATCH")
+	r.HandleFunc("/authors/{id}", deleteOneAuthor).Methods("DELETE")
+	r.HandleFunc("/authors/{id}/courses", getAuthorCourses).Methods("GET")
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "No route matches "+r.URL.Path)
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+	})
+	return r
 }
 
 // controllers - file
@@ -45,11 +292,118 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("<h1>Welcome to golang tutorial...This is Utsho Dey</h1>"))
 }
 
+// getAllCourses searches the catalog: ?q= matches words of the course
+// name by prefix, min_price, max_price (minor units), currency, author and
+// tag (repeatable) filter, sort=created|price|name|rating with order=asc|desc orders, and page
+// and per_page paginate. The total is sent in X-Total-Count. Prices in
+// different currencies are not comparable, so min_price, max_price and
+// sort=price need a currency filter; they are not converted.
 func getAllCourses(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("get all courses")
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(courses)
+
+	query, err := parseCourseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	courses, total := catalog.Search(query)
+	setPageHeaders(w, r, query.Page, query.PerPage, total)
+	writeCourses(w, r, courses)
+}
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+func parseCourseQuery(r *http.Request) (CourseQuery, error) {
+	values := r.URL.Query()
+	query := CourseQuery{
+		Text:     values.Get("q"),
+		AuthorId: values.Get("author"),
+		Currency: strings.ToUpper(values.Get("currency")),
+		Tags:     values["tag"],
+		Sort:     values.Get("sort"),
+		Page:     1,
+		PerPage:  defaultPerPage,
+	}
+
+	switch query.Sort {
+	case "", "created", "price", "name", "rating":
+	default:
+		return query, fmt.Errorf("sort must be created, price, name or rating")
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
+	}
+
+	for name, target := range map[string]**int{"min_price": &query.MinPrice, "max_price": &query.MaxPrice} {
+		if value := values.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return query, fmt.Errorf("%s must be a whole number", name)
+			}
+			*target = &n
+		}
+	}
+	if query.MinPrice != nil && query.MaxPrice != nil && *query.MinPrice > *query.MaxPrice {
+		return query, fmt.Errorf("min_price cannot be more than max_price")
+	}
+	if query.Currency == "" && (query.MinPrice != nil || query.MaxPrice != nil || query.Sort == "price") {
+		return query, fmt.Errorf("min_price, max_price and sort=price need a currency, prices in different currencies cannot be compared")
+	}
+
+	if value := values.Get("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return query, fmt.Errorf("page must be a number from 1")
+		}
+		query.Page = n
+	}
+	if value := values.Get("per_page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPerPage {
+			return query, fmt.Errorf("per_page must be a number from 1 to %d", maxPerPage)
+		}
+		query.PerPage = n
+	}
+	// the page starts at (page-1)*per_page, which has to fit in an int
+	if query.Page > math.MaxInt/query.PerPage {
+		return query, fmt.Errorf("page must be a number from 1 to %d", math.MaxInt/query.PerPage)
+	}
+	return query, nil
+}
+
+// setPageHeaders sends the total count and RFC 8288 links to the
+// neighbouring pages
+func setPageHeaders(w http.ResponseWriter, r *http.Request, page, perPage, total int) {
+	pages := max(1, (total+perPage-1)/perPage)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("X-Total-Pages", strconv.Itoa(pages))
+
+	link := func(page int, rel string) string {
+		u := *r.URL
+		values := u.Query()
+		values.Set("page", strconv.Itoa(page))
+		values.Set("per_page", strconv.Itoa(perPage))
+		u.RawQuery = values.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+	links := []string{link(1, "first"), link(pages, "last")}
+	if page > 1 {
+		links = append(links, link(min(page-1, pages), "prev"))
+	}
+	if page < pages {
+		links = append(links, link(page+1, "next"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
 }
 
 func getOneCourse(w http.ResponseWriter, r *http.Request) {
@@ -60,45 +414,181 @@ func getOneCourse(w http.ResponseWriter, r *http.Request) {
 	// grab id from request
 	params := mux.Vars(r)
 
-	// loop through courses, find matching id and return the response
-	for _, course := range courses {
-		if course.CourseId == params["id"] {
-			json.NewEncoder(w).Encode(course)
-			return
-		}
+	course, err := catalog.Get(params["id"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
 	}
-	json.NewEncoder(w).Encode("No course found with given id")
-	return
+	writeCourse(w, r, course)
 }
 
 func createOneCourse(w http.ResponseWriter, r *http.Request) {
 
-	fmt.Println("Get one course")
+	fmt.Println("Create one course")
 	w.Header().Set("Content-Type", "application/json")
 
-	// what if : body is empty
-	if r.Body == nil {
-		json.NewEncoder(w).Encode("Please send some data")
+	// empty bodies, non-JSON and unknown fields are refused here
+	var course Course
+	if !decodeJSON(w, r, &course) {
 		return
 	}
 
 	// what about - {}
-	var course Course
+	if course.IsEmpty() {
+		writeFieldError(w, http.StatusUnprocessableEntity, "coursename", "coursename is required")
+		return
+	}
 
-	_ = json.NewDecoder(r.Body).Decode(&course)
+	// the server picks the id
+	if course.CourseId != "" {
+		writeFieldError(w, http.StatusUnprocessableEntity, "courseid", "courseid is assigned by the server, leave it out")
+		return
+	}
+	if course.Author != nil {
+		writeFieldError(w, http.StatusUnprocessableEntity, "author", "Reference the author by authorid; create authors at /authors")
+		return
+	}
 
-	if course.IsEmpty() {
-		json.NewEncoder(w).Encode("No data inside JSON")
+	course, err := catalog.Add(course)
+	if err != nil {
+		writeCatalogError(w, err)
 		return
 	}
+	writeCreated(w, "/courses/"+course.CourseId)
+	writeCourse(w, r, course)
+}
 
-	// generate unique id, string
-	// append course into courses
+func updateOneCourse(w http.ResponseWriter, r *http.Request) {
 
-	rand.Seed(time.Now().UnixNano())
-	course.CourseId = strconv.Itoa(rand.Intn(100))
-	courses = append(courses, course)
-	json.NewEncoder(w).Encode(course)
-	return
+	fmt.Println("Update one course")
+	w.Header().Set("Content-Type", "application/json")
+
+	params := mux.Vars(r)
+
+	var replacement Course
+	if !decodeJSON(w, r, &replacement) {
+		return
+	}
+	if replacement.IsEmpty() {
+		writeFieldError(w, http.StatusUnprocessableEntity, "coursename", "coursename is required")
+		return
+	}
+	if replacement.CourseId != "" && replacement.CourseId != params["id"] {
+		writeFieldError(w, http.StatusUnprocessableEntity, "courseid", "courseid cannot be changed")
+		return
+	}
+	if replacement.Author != nil {
+		writeFieldError(w, http.StatusUnprocessableEntity, "author", "Reference the author by authorid; create authors at /authors")
+		return
+	}
 
+	// PUT replaces the whole course but keeps its id
+	course, err := catalog.Update(params["id"], func(c *Course) {
+		*c = replacement
+	})
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	writeCourse(w, r, course)
+}
+
+func patchOneCourse(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Patch one course")
+	w.Header().Set("Content-Type", "application/json")
+
+	params := mux.Vars(r)
+
+	var patch CoursePatch
+	if !decodeJSON(w, r, &patch) {
+		return
+	}
+	if patch.CourseName != nil && *patch.CourseName == "" {
+		writeFieldError(w, http.StatusUnprocessableEntity, "coursename", "coursename cannot be empty")
+		return
+	}
+	if patch.Author != nil {
+		writeFieldError(w, http.StatusUnprocessableEntity, "author", "Reference the author by authorid; create authors at /authors")
+		return
+	}
+
+	course, err := catalog.Update(params["id"], func(c *Course) {
+		if patch.CourseName != nil {
+			c.CourseName = *patch.CourseName
+		}
+		if patch.CoursePrice != nil {
+			c.CoursePrice = *patch.CoursePrice
+		}
+		if patch.Currency != nil {
+			c.Currency = *patch.Currency
+		}
+		if patch.Discounts != nil {
+			c.Discounts = *patch.Discounts
+		}
+		if patch.Tags != nil {
+			c.Tags = *patch.Tags
+		}
+		if patch.Capacity != nil {
+			c.Capacity = *patch.Capacity
+		}
+		if patch.AuthorId != nil {
+			c.AuthorId = *patch.AuthorId
+		}
+	})
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	writeCourse(w, r, course)
+}
+
+func deleteOneCourse(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Delete one course")
+
+	params := mux.Vars(r)
+
+	if _, err := catalog.Delete(params["id"]); err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeCatalogError maps a catalog error to its status code
+func writeCatalogError(w http.ResponseWriter, err error) {
+	var invalid *ValidationError
+	switch {
+	case errors.Is(err, errCourseNotFound):
+		writeError(w, http.StatusNotFound, "No course found with given id")
+	case errors.Is(err, errAuthorNotFound):
+		writeError(w, http.StatusNotFound, "No author found with given id")
+	case errors.Is(err, errAlreadyEnrolled):
+		writeError(w, http.StatusConflict, "Student is already enrolled or waitlisted for this course")
+	case errors.Is(err, errEnrollmentNotFound):
+		writeError(w, http.StatusNotFound, "Student is not enrolled or waitlisted for this course")
+	case errors.Is(err, errAlreadyReviewed):
+		writeError(w, http.StatusConflict, "User has already reviewed this course; edit the review with PUT instead")
+	case errors.Is(err, errReviewNotFound):
+		writeError(w, http.StatusNotFound, "No review found for this user and course")
+	case errors.Is(err, errSectionNotFound):
+		writeError(w, http.StatusNotFound, "No section found with given id")
+	case errors.Is(err, errLessonNotFound):
+		writeError(w, http.StatusNotFound, "No lesson found with given id")
+	case errors.Is(err, errNotEnrolled):
+		writeError(w, http.StatusForbidden, "Learner does not hold a seat in this course")
+	case errors.Is(err, errCouponNotFound):
+		writeError(w, http.StatusNotFound, "No coupon found with given code")
+	case errors.Is(err, errCouponExists):
+		writeError(w, http.StatusConflict, "A coupon with this code already exists")
+	case errors.As(err, &invalid):
+		writeFieldError(w, http.StatusUnprocessableEntity, invalid.Field, invalid.Error())
+	case errors.Is(err, errUnknownAuthor):
+		writeFieldError(w, http.StatusUnprocessableEntity, "authorid", "authorid does not name an existing author")
+	case errors.Is(err, errAuthorHasCourses):
+		writeError(w, http.StatusConflict, "Author still has courses; delete them first or pass ?cascade=true")
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
 }
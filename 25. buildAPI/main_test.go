@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// useTestCatalog points the handlers at a new seeded catalog for one test
+func useTestCatalog(t *testing.T) *Catalog {
+	t.Helper()
+	saved := catalog
+	catalog = NewCatalog(&ULIDGenerator{})
+	seedCatalog(catalog)
+	t.Cleanup(func() { catalog = saved })
+	return catalog
+}
+
+func TestCoursePageBounds(t *testing.T) {
+	useTestCatalog(t)
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"page=1", http.StatusOK},
+		{"page=0", http.StatusBadRequest},
+		{"page=-3", http.StatusBadRequest},
+		{fmt.Sprintf("page=%d", math.MaxInt/defaultPerPage), http.StatusOK},
+		{fmt.Sprintf("page=%d", math.MaxInt/defaultPerPage+1), http.StatusBadRequest},
+		{fmt.Sprintf("page=%d&per_page=%d", math.MaxInt/maxPerPage+1, maxPerPage), http.StatusBadRequest},
+		{fmt.Sprintf("page=%d&sort=price&order=desc&currency=USD", math.MaxInt), http.StatusBadRequest},
+		{fmt.Sprintf("page=%d&tag=javascript&sort=name", math.MaxInt/defaultPerPage), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getAllCourses(w, httptest.NewRequest(http.MethodGet, "/courses?"+tt.query, nil))
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestPriceQueriesNeedACurrency(t *testing.T) {
+	c := useTestCatalog(t)
+	if _, err := c.Add(Course{CourseName: "Go", CoursePrice: 25000, Currency: "JPY"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		count  int
+	}{
+		{"min_price=100", http.StatusBadRequest, 0},
+		{"max_price=100", http.StatusBadRequest, 0},
+		{"sort=price", http.StatusBadRequest, 0},
+		{"sort=name", http.StatusOK, 3},
+		{"min_price=20000&currency=USD", http.StatusOK, 1},
+		{"min_price=20000&currency=jpy", http.StatusOK, 1},
+		{"sort=price&currency=USD", http.StatusOK, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getAllCourses(w, httptest.NewRequest(http.MethodGet, "/courses?"+tt.query, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if count := w.Header().Get("X-Total-Count"); count != strconv.Itoa(tt.count) {
+				t.Errorf("X-Total-Count %s, want %d", count, tt.count)
+			}
+		})
+	}
+}
+
+// send serves one request through the router
+func send(method, target, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, req)
+	return w
+}
+
+func TestDecodeJSONStatus(t *testing.T) {
+	useTestCatalog(t)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		field       string
+	}{
+		{"created", "application/json", `{"coursename":"Go","price":1000,"currency":"USD"}`, http.StatusCreated, ""},
+		{"json suffix", "application/vnd.api+json", `{"coursename":"Go","price":1000,"currency":"USD"}`, http.StatusCreated, ""},
+		{"no content type", "", `{"coursename":"Go"}`, http.StatusUnsupportedMediaType, ""},
+		{"form content type", "application/x-www-form-urlencoded", `coursename=Go`, http.StatusUnsupportedMediaType, ""},
+		{"too large", "application/json", `{"coursename":"` + strings.Repeat("x", maxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, ""},
+		{"unknown field", "application/json", `{"coursename":"Go","bogus":1}`, http.StatusBadRequest, "bogus"},
+		{"wrong type", "application/json", `{"coursename":"Go","price":"ten"}`, http.StatusBadRequest, "price"},
+		{"trailing data", "application/json", `{"coursename":"Go"} {}`, http.StatusBadRequest, ""},
+		{"empty body", "application/json", ``, http.StatusBadRequest, ""},
+		{"invalid json", "application/json", `{"coursename":`, http.StatusBadRequest, ""},
+		{"invalid course", "application/json", `{"coursename":"Go","price":1000,"currency":"USD","capacity":-1}`, http.StatusUnprocessableEntity, "capacity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(http.MethodPost, "/courses", tt.contentType, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusCreated {
+				if !strings.HasPrefix(w.Header().Get("Location"), "/courses/") {
+					t.Errorf("Location %q", w.Header().Get("Location"))
+				}
+				return
+			}
+			var body struct{ Error APIError }
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("error body %q: %v", w.Body, err)
+			}
+			if body.Error.Status != tt.status || body.Error.Field != tt.field {
+				t.Errorf("error %+v, want status %d and field %q", body.Error, tt.status, tt.field)
+			}
+		})
+	}
+}
+
+func TestDeletesAnswerNoContent(t *testing.T) {
+	c := useTestCatalog(t)
+	author, _ := c.AddAuthor(Author{Fullname: "Ada Lovelace"})
+	course, _ := c.Add(Course{CourseName: "Go", CoursePrice: 1000, Currency: "USD", AuthorId: author.AuthorId})
+
+	created := send(http.MethodPost, "/coupons", "application/json", `{"code":"spring sale","kind":"percent","percent":10}`)
+	location := created.Header().Get("Location")
+	if created.Code != http.StatusCreated || location != "/coupons/SPRING%20SALE" {
+		t.Fatalf("coupon created with %d at %q", created.Code, location)
+	}
+
+	for _, target := range []string{location, "/courses/" + course.CourseId, "/authors/" + author.AuthorId} {
+		w := send(http.MethodDelete, target, "", "")
+		if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+			t.Errorf("DELETE %s: %d %q, want 204 with no body", target, w.Code, w.Body)
+		}
+		w = send(http.MethodDelete, target, "", "")
+		if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("DELETE %s again: %d %s, want a 404 error body", target, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Prices are whole numbers of the currency's minor unit (cents for USD,
+// yen for JPY), so no arithmetic is done in floating point.
+
+// currencyExponents lists the ISO 4217 currencies courses can be priced in
+// and how many decimal places their minor unit has
+var currencyExponents = map[string]int{
+	"AUD": 2, "BDT": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2,
+	"DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "IDR": 2, "INR": 2, "JPY": 0,
+	"KRW": 0, "KWD": 3, "MXN": 2, "NOK": 2, "NZD": 2, "PKR": 2, "PLN": 2,
+	"SEK": 2, "SGD": 2, "USD": 2, "ZAR": 2,
+}
+
+const defaultCurrency = "USD"
+
+// ValidationError reports data the catalog cannot accept
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// formatMoney renders an amount of minor units, e.g. 29900 USD as "299.00 USD"
+func formatMoney(amount int, currency string) string {
+	exp := currencyExponents[currency]
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if exp == 0 {
+		return fmt.Sprintf("%s%d %s", sign, amount, currency)
+	}
+	scale := 1
+	for range exp {
+		scale *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/scale, exp, amount%scale, currency)
+}
+
+// percentOf returns percent % of amount in minor units, rounding half up
+// (e.g. 15% of 1999 is 299.85, which becomes 300)
+func percentOf(amount, percent int) int {
+	return (amount*percent + 50) / 100
+}
+
+// Discount kinds
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// Discount takes a percentage or a fixed amount off a course between
+// StartsAt and EndsAt; either bound may be left out
+type Discount struct {
+	Kind     string     `json:"kind"`
+	Percent  int        `json:"percent,omitempty"` // whole percent, for percent discounts
+	Amount   int        `json:"amount,omitempty"`  // minor units, for fixed discounts
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+}
+
+func (d Discount) validate(field string) error {
+	switch d.Kind {
+	case DiscountPercent:
+		if d.Percent < 1 || d.Percent > 100 || d.Amount != 0 {
+			return &ValidationError{field, "must have a percent from 1 to 100 and no amount"}
+		}
+	case DiscountFixed:
+		if d.Amount < 1 || d.Percent != 0 {
+			return &ValidationError{field, "must have a positive amount and no percent"}
+		}
+	default:
+		return &ValidationError{field + ".kind", "must be percent or fixed"}
+	}
+	if d.StartsAt != nil && d.EndsAt != nil && !d.EndsAt.After(*d.StartsAt) {
+		return &ValidationError{field + ".ends_at", "must be after starts_at"}
+	}
+	return nil
+}
+
+// activeAt reports whether the discount applies at t
+func (d Discount) activeAt(t time.Time) bool {
+	return (d.StartsAt == nil || !t.Before(*d.StartsAt)) && (d.EndsAt == nil || t.Before(*d.EndsAt))
+}
+
+// off returns how many minor units the discount takes off price; it never
+// takes off more than the price
+func (d Discount) off(price int) int {
+	if d.Kind == DiscountPercent {
+		return percentOf(price, d.Percent)
+	}
+	return min(d.Amount, price)
+}
+
+// Coupon is a code that takes a percentage or a fixed amount off, at most
+// MaxUses times (0 means no limit). Fixed coupons have a currency and only
+// apply to courses priced in it; CourseIds, if set, limits the courses.
+type Coupon struct {
+	Code      string     `json:"code"`
+	Kind      string     `json:"kind"`
+	Percent   int        `json:"percent,omitempty"`
+	Amount    int        `json:"amount,omitempty"`
+	Currency  string     `json:"currency,omitempty"`
+	CourseIds []string   `json:"courseids,omitempty"`
+	StartsAt  *time.Time `json:"starts_at,omitempty"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+}
+
+func (c Coupon) discount() Discount {
+	return Discount{Kind: c.Kind, Percent: c.Percent, Amount: c.Amount, StartsAt: c.StartsAt, EndsAt: c.EndsAt}
+}
+
+// normalize upper-cases the code and currency and checks the coupon
+func (c *Coupon) normalize() error {
+	c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+	c.Currency = strings.ToUpper(strings.TrimSpace(c.Currency))
+	if c.Code == "" {
+		return &ValidationError{"code", "is required"}
+	}
+	if err := c.discount().validate("coupon"); err != nil {
+		return err
+	}
+	if c.Kind == DiscountFixed {
+		if _, ok := currencyExponents[c.Currency]; !ok {
+			return &ValidationError{"currency", "must be a supported ISO 4217 code for fixed coupons"}
+		}
+	} else if c.Currency != "" {
+		return &ValidationError{"currency", "only applies to fixed coupons"}
+	}
+	if c.MaxUses < 0 {
+		return &ValidationError{"max_uses", "cannot be negative"}
+	}
+	return nil
+}
+
+// usableFor explains why the coupon cannot be used on course at t, or
+// returns nil
+func (c Coupon) usableFor(course Course, t time.Time) error {
+	switch {
+	case !c.discount().activeAt(t):
+		return &ValidationError{"coupon", "is not valid at this time"}
+	case c.MaxUses > 0 && c.Uses >= c.MaxUses:
+		return &ValidationError{"coupon", "has been used up"}
+	case c.Kind == DiscountFixed && c.Currency != course.Currency:
+		return &ValidationError{"coupon", "is in " + c.Currency + " but the course is priced in " + course.Currency}
+	}
+	if len(c.CourseIds) > 0 {
+		for _, id := range c.CourseIds {
+			if id == course.CourseId {
+				return nil
+			}
+		}
+		return &ValidationError{"coupon", "does not apply to this course"}
+	}
+	return nil
+}
+
+// normalizePricing defaults and checks the currency, price and discounts
+// of a course
+func normalizePricing(course *Course) error {
+	course.Currency = strings.ToUpper(strings.TrimSpace(course.Currency))
+	if course.Currency == "" {
+		course.Currency = defaultCurrency
+	}
+	if _, ok := currencyExponents[course.Currency]; !ok {
+		return &ValidationError{"currency", "must be a supported ISO 4217 code"}
+	}
+	if course.CoursePrice < 0 {
+		return &ValidationError{"price", "cannot be negative"}
+	}
+	for i, discount := range course.Discounts {
+		if err := discount.validate(fmt.Sprintf("discounts[%d]", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QuoteLine is one step from the list price to the total
+type QuoteLine struct {
+	Label   string `json:"label"`
+	Amount  int    `json:"amount"`
+	Display string `json:"display"`
+}
+
+// Quote is what a course costs at a moment, with and without a coupon
+type Quote struct {
+	CourseId     string      `json:"courseid"`
+	Currency     string      `json:"currency"`
+	ListPrice    int         `json:"list_price"`
+	Lines        []QuoteLine `json:"lines"`
+	Total        int         `json:"total"`
+	TotalDisplay string      `json:"total_display"`
+	QuotedAt     time.Time   `json:"quoted_at"`
+}
+
+// quoteCourse prices course at t. Of the course's active discounts the
+// one worth most is applied to the list price, then the coupon, if any, to
+// what is left. Each step is rounded to a whole minor unit before the next,
+// so the lines always add up to the total.
+func quoteCourse(course Course, coupon *Coupon, t time.Time) Quote {
+	quote := Quote{
+		CourseId:  course.CourseId,
+		Currency:  course.Currency,
+		ListPrice: course.CoursePrice,
+		QuotedAt:  t,
+	}
+	line := func(label string, amount int) {
+		quote.Lines = append(quote.Lines, QuoteLine{label, amount, formatMoney(amount, course.Currency)})
+	}
+
+	price := course.CoursePrice
+	line("List price", price)
+
+	var best *Discount
+	for i, discount := range course.Discounts {
+		if discount.activeAt(t) && (best == nil || discount.off(price) > best.off(price)) {
+			best = &course.Discounts[i]
+		}
+	}
+	if best != nil {
+		off := best.off(price)
+		line(discountLabel("Course discount", *best), -off)
+		price -= off
+	}
+
+	if coupon != nil {
+		off := coupon.discount().off(price)
+		line(discountLabel("Coupon "+coupon.Code, coupon.discount()), -off)
+		price -= off
+	}
+
+	quote.Total = price
+	quote.TotalDisplay = formatMoney(price, course.Currency)
+	return quote
+}
+
+func discountLabel(name string, d Discount) string {
+	if d.Kind == DiscountPercent {
+		return fmt.Sprintf("%s (%d%% off)", name, d.Percent)
+	}
+	return name
+}
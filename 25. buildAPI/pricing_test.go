@@ -0,0 +1,198 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentOfRoundsHalfUp(t *testing.T) {
+	tests := []struct {
+		amount, percent, want int
+	}{
+		{1999, 15, 300}, // 299.85
+		{1000, 15, 150},
+		{10, 5, 1}, // 0.5 rounds up
+		{10, 4, 0}, // 0.4 rounds down
+		{30, 5, 2}, // 1.5 rounds up
+		{999, 100, 999},
+		{0, 50, 0},
+	}
+	for _, tt := range tests {
+		if got := percentOf(tt.amount, tt.percent); got != tt.want {
+			t.Errorf("percentOf(%d, %d) = %d, want %d", tt.amount, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	tests := []struct {
+		amount   int
+		currency string
+		want     string
+	}{
+		{29900, "USD", "299.00 USD"},
+		{5, "EUR", "0.05 EUR"},
+		{-1999, "USD", "-19.99 USD"},
+		{1500, "JPY", "1500 JPY"},
+		{1500, "KRW", "1500 KRW"},
+		{12345, "KWD", "12.345 KWD"},
+		{7, "KWD", "0.007 KWD"},
+		{-1, "BHD", "-0.001 BHD"},
+	}
+	for _, tt := range tests {
+		if got := formatMoney(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("formatMoney(%d, %s) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestDiscountOff(t *testing.T) {
+	tests := []struct {
+		name     string
+		discount Discount
+		price    int
+		want     int
+	}{
+		{"percent", Discount{Kind: DiscountPercent, Percent: 15}, 1999, 300},
+		{"full percent", Discount{Kind: DiscountPercent, Percent: 100}, 1999, 1999},
+		{"fixed", Discount{Kind: DiscountFixed, Amount: 500}, 1999, 500},
+		{"fixed capped at the price", Discount{Kind: DiscountFixed, Amount: 5000}, 1999, 1999},
+		{"fixed on a free course", Discount{Kind: DiscountFixed, Amount: 500}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.discount.off(tt.price); got != tt.want {
+				t.Errorf("off(%d) = %d, want %d", tt.price, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteCourse(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		course Course
+		coupon *Coupon
+		total  int
+		lines  []int
+	}{
+		{
+			name:   "list price",
+			course: Course{CoursePrice: 1999, Currency: "USD"},
+			total:  1999,
+			lines:  []int{1999},
+		},
+		{
+			name: "best active discount wins",
+			course: Course{CoursePrice: 10000, Currency: "USD", Discounts: []Discount{
+				{Kind: DiscountPercent, Percent: 10},
+				{Kind: DiscountFixed, Amount: 1500},
+				{Kind: DiscountPercent, Percent: 50, EndsAt: &past}, // over
+				{Kind: DiscountPercent, Percent: 60, StartsAt: &future},
+			}},
+			total: 8500,
+			lines: []int{10000, -1500},
+		},
+		{
+			name:   "coupon on top of a discount",
+			course: Course{CoursePrice: 1999, Currency: "USD", Discounts: []Discount{{Kind: DiscountPercent, Percent: 15}}},
+			coupon: &Coupon{Code: "TEN", Kind: DiscountPercent, Percent: 10},
+			total:  1529, // 1999 - 300 = 1699, less 170
+			lines:  []int{1999, -300, -170},
+		},
+		{
+			name:   "fixed coupon capped at what is left",
+			course: Course{CoursePrice: 2000, Currency: "USD", Discounts: []Discount{{Kind: DiscountPercent, Percent: 90}}},
+			coupon: &Coupon{Code: "BIG", Kind: DiscountFixed, Amount: 1000, Currency: "USD"},
+			total:  0,
+			lines:  []int{2000, -1800, -200},
+		},
+		{
+			name:   "JPY has no minor unit",
+			course: Course{CoursePrice: 1999, Currency: "JPY"},
+			coupon: &Coupon{Code: "JP", Kind: DiscountPercent, Percent: 15},
+			total:  1699,
+			lines:  []int{1999, -300},
+		},
+		{
+			name:   "KWD has three decimals",
+			course: Course{CoursePrice: 10005, Currency: "KWD", Discounts: []Discount{{Kind: DiscountFixed, Amount: 5}}},
+			total:  10000,
+			lines:  []int{10005, -5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote := quoteCourse(tt.course, tt.coupon, now)
+			if quote.Total != tt.total {
+				t.Errorf("total = %d, want %d", quote.Total, tt.total)
+			}
+			if len(quote.Lines) != len(tt.lines) {
+				t.Fatalf("lines = %+v, want amounts %v", quote.Lines, tt.lines)
+			}
+			sum := 0
+			for i, line := range quote.Lines {
+				if line.Amount != tt.lines[i] {
+					t.Errorf("line %d (%s) = %d, want %d", i, line.Label, line.Amount, tt.lines[i])
+				}
+				if want := formatMoney(line.Amount, tt.course.Currency); line.Display != want {
+					t.Errorf("line %d display = %q, want %q", i, line.Display, want)
+				}
+				sum += line.Amount
+			}
+			if sum != quote.Total {
+				t.Errorf("lines add up to %d, total is %d", sum, quote.Total)
+			}
+		})
+	}
+}
+
+func TestQuoteDisplays(t *testing.T) {
+	tests := []struct {
+		price    int
+		currency string
+		want     string
+	}{
+		{1999, "JPY", "1999 JPY"},
+		{1999, "KWD", "1.999 KWD"},
+		{1999, "USD", "19.99 USD"},
+	}
+	for _, tt := range tests {
+		quote := quoteCourse(Course{CoursePrice: tt.price, Currency: tt.currency}, nil, time.Now())
+		if quote.TotalDisplay != tt.want {
+			t.Errorf("%d %s displays as %q, want %q", tt.price, tt.currency, quote.TotalDisplay, tt.want)
+		}
+	}
+}
+
+func TestCouponUsableFor(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	course := Course{CourseId: "c1", Currency: "USD"}
+
+	tests := []struct {
+		name   string
+		coupon Coupon
+		ok     bool
+	}{
+		{"percent", Coupon{Kind: DiscountPercent, Percent: 10}, true},
+		{"fixed in the course currency", Coupon{Kind: DiscountFixed, Amount: 100, Currency: "USD"}, true},
+		{"fixed in another currency", Coupon{Kind: DiscountFixed, Amount: 100, Currency: "EUR"}, false},
+		{"not started", Coupon{Kind: DiscountPercent, Percent: 10, StartsAt: &later}, false},
+		{"used up", Coupon{Kind: DiscountPercent, Percent: 10, MaxUses: 2, Uses: 2}, false},
+		{"uses left", Coupon{Kind: DiscountPercent, Percent: 10, MaxUses: 2, Uses: 1}, true},
+		{"for this course", Coupon{Kind: DiscountPercent, Percent: 10, CourseIds: []string{"c0", "c1"}}, true},
+		{"for other courses", Coupon{Kind: DiscountPercent, Percent: 10, CourseIds: []string{"c2"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.coupon.usableFor(course, now)
+			if (err == nil) != tt.ok {
+				t.Errorf("usableFor = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
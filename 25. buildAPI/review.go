@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	errAlreadyReviewed = errors.New("user has already reviewed this course")
+	errReviewNotFound  = errors.New("no review found for this user and course")
+)
+
+// Review moderation states; only approved reviews count towards a course's
+// rating or are listed by default
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+const maxReviewText = 5000
+
+// Review is one user's rating of a course. A user has at most one review
+// per course; editing it sends it back to moderation.
+type Review struct {
+	ReviewId  string    `json:"reviewid"`
+	CourseId  string    `json:"courseid"`
+	UserId    string    `json:"user_id"`
+	Rating    int       `json:"rating"` // stars, 1 to 5
+	Text      string    `json:"text"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (r *Review) validate() error {
+	r.Text = strings.TrimSpace(r.Text)
+	if r.Rating < 1 || r.Rating > 5 {
+		return &ValidationError{"rating", "must be from 1 to 5"}
+	}
+	if len(r.Text) > maxReviewText {
+		return &ValidationError{"text", "is too long"}
+	}
+	return nil
+}
+
+// Rating sums up the approved reviews of a course. It is kept up to date
+// as reviews are approved, edited and removed rather than recounted.
+type Rating struct {
+	Average   float64 `json:"average"` // 0 without reviews
+	Count     int     `json:"count"`
+	Histogram [5]int  `json:"histogram"` // reviews with 1 to 5 stars
+}
+
+// add counts n more reviews with the given stars; n is -1 to take one away
+func (r *Rating) add(stars, n int) {
+	r.Histogram[stars-1] += n
+	r.Count += n
+	r.Average = 0
+	if r.Count > 0 {
+		sum := 0
+		for i, count := range r.Histogram {
+			sum += (i + 1) * count
+		}
+		r.Average = math.Round(float64(sum)/float64(r.Count)*100) / 100
+	}
+}
+
+type reviewKey struct {
+	courseID, userID string
+}
+
+// rate adds n approved reviews with the given stars to a course's rating,
+// re-indexing it; callers must hold s.mu
+func (s *Catalog) rate(courseID string, stars, n int) {
+	course := s.courses[courseID]
+	s.index.remove(course)
+	course.Rating.add(stars, n)
+	s.courses[courseID] = course
+	s.index.add(course)
+}
+
+// AddReview stores a user's first review of a course, pending moderation
+func (s *Catalog) AddReview(review Review, t time.Time) (Review, error) {
+	review.UserId = strings.TrimSpace(review.UserId)
+	if review.UserId == "" {
+		return Review{}, &ValidationError{"user_id", "is required"}
+	}
+	if err := review.validate(); err != nil {
+		return Review{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.courses[review.CourseId]; !ok {
+		return Review{}, errCourseNotFound
+	}
+	key := reviewKey{review.CourseId, review.UserId}
+	if _, ok := s.reviews[key]; ok {
+		return Review{}, errAlreadyReviewed
+	}
+	id, err := s.newID()
+	if err != nil {
+		return Review{}, err
+	}
+	review.ReviewId = id
+	review.Status = ReviewPending
+	review.CreatedAt = t
+	review.UpdatedAt = t
+	s.reviews[key] = review
+	s.reviewers.add(review.CourseId, review.UserId)
+	return review, nil
+}
+
+// EditReview changes the rating and text of a user's review and sends it
+// back to moderation, taking it out of the course's rating until then
+func (s *Catalog) EditReview(courseID, userID string, rating int, text string, t time.Time) (Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	review, err := s.review(courseID, userID)
+	if err != nil {
+		return Review{}, err
+	}
+	old := review
+	review.Rating = rating
+	review.Text = text
+	if err := review.validate(); err != nil {
+		return Review{}, err
+	}
+	if old.Status == ReviewApproved {
+		s.rate(courseID, old.Rating, -1)
+	}
+	review.Status = ReviewPending
+	review.UpdatedAt = t
+	s.reviews[reviewKey{courseID, userID}] = review
+	return review, nil
+}
+
+// ModerateReview approves or rejects a review, counting it in or out of
+// the course's rating
+func (s *Catalog) ModerateReview(courseID, userID, status string, t time.Time) (Review, error) {
+	if status != ReviewApproved && status != ReviewRejected && status != ReviewPending {
+		return Review{}, &ValidationError{"status", "must be pending, approved or rejected"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	review, err := s.review(courseID, userID)
+	if err != nil {
+		return Review{}, err
+	}
+	if review.Status == status {
+		return review, nil
+	}
+	if review.Status == ReviewApproved {
+		s.rate(courseID, review.Rating, -1)
+	}
+	if status == ReviewApproved {
+		s.rate(courseID, review.Rating, 1)
+	}
+	review.Status = status
+	review.UpdatedAt = t
+	s.reviews[reviewKey{courseID, userID}] = review
+	return review, nil
+}
+
+// DeleteReview removes a user's review of a course
+func (s *Catalog) DeleteReview(courseID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	review, err := s.review(courseID, userID)
+	if err != nil {
+		return err
+	}
+	if review.Status == ReviewApproved {
+		s.rate(courseID, review.Rating, -1)
+	}
+	delete(s.reviews, reviewKey{courseID, userID})
+	s.reviewers.remove(courseID, userID)
+	return nil
+}
+
+func (s *Catalog) GetReview(courseID, userID string) (Review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.review(courseID, userID)
+}
+
+// review looks up a user's review of a course; callers must hold s.mu
+func (s *Catalog) review(courseID, userID string) (Review, error) {
+	if _, ok := s.courses[courseID]; !ok {
+		return Review{}, errCourseNotFound
+	}
+	review, ok := s.reviews[reviewKey{courseID, userID}]
+	if !ok {
+		return Review{}, errReviewNotFound
+	}
+	return review, nil
+}
+
+// CourseReviews lists the reviews of a course in a moderation state,
+// oldest first
+func (s *Catalog) CourseReviews(courseID, status string) ([]Review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.courses[courseID]; !ok {
+		return nil, errCourseNotFound
+	}
+	list := []Review{}
+	for userID := range s.reviewers[courseID] {
+		if review := s.reviews[reviewKey{courseID, userID}]; review.Status == status {
+			list = append(list, review)
+		}
+	}
+	slices.SortFunc(list, func(a, b Review) int { return strings.Compare(a.ReviewId, b.ReviewId) })
+	return list, nil
+}
+
+// dropReviews forgets every review of a course that is being deleted;
+// callers must hold s.mu
+func (s *Catalog) dropReviews(courseID string) {
+	for userID := range s.reviewers[courseID] {
+		delete(s.reviews, reviewKey{courseID, userID})
+	}
+	delete(s.reviewers, courseID)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// review controllers - file
+
+// getCourseReviews lists approved reviews, or those in ?status=
+func getCourseReviews(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get course reviews")
+	w.Header().Set("Content-Type", "application/json")
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = ReviewApproved
+	case ReviewPending, ReviewApproved, ReviewRejected:
+	default:
+		writeError(w, http.StatusBadRequest, "status must be pending, approved or rejected")
+		return
+	}
+	reviews, err := catalog.CourseReviews(mux.Vars(r)["id"], status)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(reviews)
+}
+
+func getOneReview(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Get one review")
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	review, err := catalog.GetReview(vars["id"], vars["user"])
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(review)
+}
+
+// createOneReview stores a user's review, which waits for moderation
+// before it counts towards the course's rating
+func createOneReview(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Create one review")
+	w.Header().Set("Content-Type", "application/json")
+
+	var review Review
+	if !decodeJSON(w, r, &review) {
+		return
+	}
+	review.CourseId = mux.Vars(r)["id"]
+
+	review, err := catalog.AddReview(review, time.Now().UTC())
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	writeCreated(w, "/courses/"+review.CourseId+"/reviews/"+url.PathEscape(review.UserId))
+	json.NewEncoder(w).Encode(review)
+}
+
+func updateOneReview(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Update one review")
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Rating int    `json:"rating"`
+		Text   string `json:"text"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	review, err := catalog.EditReview(vars["id"], vars["user"], body.Rating, body.Text, time.Now().UTC())
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(review)
+}
+
+// moderateOneReview sets a review's moderation status
+func moderateOneReview(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Moderate one review")
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Status string `json:"status"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	review, err := catalog.ModerateReview(vars["id"], vars["user"], body.Status, time.Now().UTC())
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(review)
+}
+
+func deleteOneReview(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Delete one review")
+
+	vars := mux.Vars(r)
+	if err := catalog.DeleteReview(vars["id"], vars["user"]); err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ServerConfig holds the listener settings read from the command line
+type ServerConfig struct {
+	Addr            string
+	TLS             TLSOptions
+	ShutdownTimeout time.Duration
+	IDFormat        string
+}
+
+func parseServerFlags(args []string) (ServerConfig, error) {
+	var cfg ServerConfig
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "4000"
+	}
+
+	fs := flag.NewFlagSet("buildapi", flag.ContinueOnError)
+	fs.StringVar(&cfg.Addr, "addr", ":"+port, "address to listen on (default :$PORT, or :4000)")
+	cfg.TLS.registerFlags(fs)
+	fs.StringVar(&cfg.IDFormat, "id-format", "uuidv7", "format of new course IDs: uuidv7 or ulid")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to wait for open requests on shutdown")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.TLS.check()
+}
+
+// serve runs handler until SIGINT or SIGTERM, then stops accepting
+// connections and waits up to cfg.ShutdownTimeout for open requests
+func serve(cfg ServerConfig, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 2)
+	servers, err := cfg.TLS.listen(&http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}, errs)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	var shutdownErr error
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			shutdownErr = err
+		}
+	}
+	return shutdownErr
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errCourseNotFound   = errors.New("no course found with given id")
+	errAuthorNotFound   = errors.New("no author found with given id")
+	errUnknownAuthor    = errors.New("authorid does not name an existing author")
+	errAuthorHasCourses = errors.New("author still has courses; delete them first or pass ?cascade=true")
+	errIDCollision      = errors.New("could not generate a unique id")
+	errCouponNotFound   = errors.New("no coupon found with given code")
+	errCouponExists     = errors.New("a coupon with this code already exists")
+)
+
+// Catalog keeps authors and courses in memory. Courses refer to their
+// author by ID, and both live under one lock so a course can never point
+// at an author that is being deleted. It is safe for use by concurrent
+// requests.
+type Catalog struct {
+	mu      sync.RWMutex
+	authors map[string]Author
+	courses map[string]Course
+	coupons map[string]Coupon
+	index   *courseIndex
+	ids     IDGenerator
+	issued  idSet // every ID newID handed out
+
+	enrollments map[string]Enrollment
+	rosters     map[string]*roster // by course ID
+	byStudent   setIndex           // student ID to enrollment IDs
+
+	reviews   map[reviewKey]Review
+	reviewers setIndex // course ID to the IDs of users who reviewed it
+
+	curricula map[string][]Section // by course ID
+	progress  map[learnerKey]*progress
+	learners  setIndex // course ID to the IDs of learners with progress
+}
+
+// NewCatalog creates a catalog that names authors and courses with ids.
+// Both ID formats are time ordered, so ID order is creation order.
+func NewCatalog(ids IDGenerator) *Catalog {
+	return &Catalog{
+		authors: map[string]Author{},
+		courses: map[string]Course{},
+		coupons: map[string]Coupon{},
+		index:   newCourseIndex(),
+		ids:     ids,
+		issued:  idSet{},
+
+		enrollments: map[string]Enrollment{},
+		rosters:     map[string]*roster{},
+		byStudent:   setIndex{},
+
+		reviews:   map[reviewKey]Review{},
+		reviewers: setIndex{},
+
+		curricula: map[string][]Section{},
+		progress:  map[learnerKey]*progress{},
+		learners:  setIndex{},
+	}
+}
+
+// seedCatalog adds the sample author and the courses they teach
+func seedCatalog(c *Catalog) {
+	author, _ := c.AddAuthor(Author{Fullname: "Utsho Dey", Website: "lco.dev"})
+	c.Add(Course{CourseName: "ReactJS", CoursePrice: 29900, Currency: "USD", AuthorId: author.AuthorId, Tags: []string{"javascript", "frontend"}})
+	c.Add(Course{CourseName: "MERN Stack", CoursePrice: 19900, Currency: "USD", AuthorId: author.AuthorId, Tags: []string{"javascript", "fullstack"}})
+}
+
+// fake DB, created in main once the ID format is known
+var catalog *Catalog
+
+// CourseQuery selects, sorts and pages courses. Empty fields do not filter.
+type CourseQuery struct {
+	Text     string // every word must start a word of the course name
+	MinPrice *int
+	MaxPrice *int
+	AuthorId string
+	Currency string
+	Tags     []string // the course must have all of them
+	Sort     string   // created (the default), price, name or rating
+	Desc     bool
+	Page     int // from 1
+	PerPage  int
+}
+
+// Search returns one page of the courses matching q and how many match in
+// total. The filters intersect index lookups; when nothing filters, the
+// page is cut straight out of the sort index.
+func (s *Catalog) Search(q CourseQuery) ([]Course, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches idSet // nil means every course
+	if q.Text != "" {
+		matches = intersect(matches, s.index.matchText(q.Text))
+	}
+	if q.AuthorId != "" {
+		matches = intersect(matches, s.index.byAuthor[q.AuthorId].orEmpty())
+	}
+	if q.Currency != "" {
+		matches = intersect(matches, s.index.byCurrency[q.Currency].orEmpty())
+	}
+	for _, tag := range normalizeTags(q.Tags) {
+		matches = intersect(matches, s.index.byTag[tag].orEmpty())
+	}
+	if q.MinPrice != nil || q.MaxPrice != nil {
+		lo, hi := math.MinInt, math.MaxInt
+		if q.MinPrice != nil {
+			lo = *q.MinPrice
+		}
+		if q.MaxPrice != nil {
+			hi = *q.MaxPrice
+		}
+		matches = intersect(matches, s.index.byPrice.between(lo, hi).ids())
+	}
+
+	var ids []string
+	var total int
+	start := (q.Page - 1) * q.PerPage
+	switch q.Sort {
+	case "price":
+		ids, total = s.index.byPrice.page(matches, func(id string) int { return s.courses[id].CoursePrice }, q.Desc, start, q.PerPage)
+	case "rating":
+		ids, total = s.index.byRating.page(matches, func(id string) float64 { return s.courses[id].Rating.Average }, q.Desc, start, q.PerPage)
+	case "name":
+		ids, total = s.index.byName.page(matches, func(id string) string { return strings.ToLower(s.courses[id].CourseName) }, q.Desc, start, q.PerPage)
+	default:
+		ids, total = s.index.byCreated.page(matches, func(id string) string { return id }, q.Desc, start, q.PerPage)
+	}
+
+	page := make([]Course, len(ids))
+	for i, id := range ids {
+		page[i] = s.courses[id]
+	}
+	return page, total
+}
+
+// All returns every course in creation order
+func (s *Catalog) All() []Course {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make([]Course, 0, len(s.courses))
+	for _, entry := range s.index.byCreated {
+		all = append(all, s.courses[entry.id])
+	}
+	return all
+}
+
+func (s *Catalog) Get(id string) (Course, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if course, ok := s.courses[id]; ok {
+		return course, nil
+	}
+	return Course{}, errCourseNotFound
+}
+
+// Add stores course under a new ID, ignoring any ID it already has. Its
+// author, if it names one, must exist.
+func (s *Catalog) Add(course Course) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.add(course)
+}
+
+// add is Add for callers that already hold s.mu
+func (s *Catalog) add(course Course) (Course, error) {
+	if _, ok := s.authors[course.AuthorId]; course.AuthorId != "" && !ok {
+		return Course{}, errUnknownAuthor
+	}
+	if err := normalizePricing(&course); err != nil {
+		return Course{}, err
+	}
+	if course.Capacity < 0 {
+		return Course{}, &ValidationError{"capacity", "cannot be negative"}
+	}
+	id, err := s.newID()
+	if err != nil {
+		return Course{}, err
+	}
+	course.CourseId = id
+	course.CreatedAt = time.Now().UTC()
+	course.Author = nil
+	course.Rating = Rating{}
+	course.Tags = normalizeTags(course.Tags)
+	s.courses[id] = course
+	s.index.add(course)
+	return course, nil
+}
+
+// Update applies change to the course with the given id while holding the
+// lock, so concurrent updates of one course cannot overwrite each other
+func (s *Catalog) Update(id string, change func(*Course)) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.courses[id]
+	if !ok {
+		return Course{}, errCourseNotFound
+	}
+	course := old
+	course.Tags = slices.Clone(old.Tags)
+	course.Discounts = slices.Clone(old.Discounts)
+	change(&course)
+	if _, ok := s.authors[course.AuthorId]; course.AuthorId != "" && !ok {
+		return Course{}, errUnknownAuthor
+	}
+	if err := normalizePricing(&course); err != nil {
+		return Course{}, err
+	}
+	course.CourseId = id
+	course.CreatedAt = old.CreatedAt
+	course.Rating = old.Rating
+	course.Author = nil
+	course.Tags = normalizeTags(course.Tags)
+	if course.Capacity < 0 {
+		return Course{}, &ValidationError{"capacity", "cannot be negative"}
+	}
+	s.index.remove(old)
+	s.courses[id] = course
+	s.index.add(course)
+	s.fillSeats(id)
+	return course, nil
+}
+
+func (s *Catalog) Delete(id string) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed, ok := s.courses[id]
+	if !ok {
+		return Course{}, errCourseNotFound
+	}
+	s.removeCourse(removed)
+	return removed, nil
+}
+
+// removeCourse drops a course with its index entries and everything
+// nested under it; callers must hold s.mu
+func (s *Catalog) removeCourse(course Course) {
+	delete(s.courses, course.CourseId)
+	s.index.remove(course)
+	s.dropEnrollments(course.CourseId)
+	s.dropReviews(course.CourseId)
+	s.dropCurriculum(course.CourseId)
+}
+
+// Authors returns every author in creation order
+func (s *Catalog) Authors() []Author {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	authors := make([]Author, 0, len(s.authors))
+	for _, author := range s.authors {
+		authors = append(authors, author)
+	}
+	slices.SortFunc(authors, func(a, b Author) int { return strings.Compare(a.AuthorId, b.AuthorId) })
+	return authors
+}
+
+func (s *Catalog) GetAuthor(id string) (Author, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if author, ok := s.authors[id]; ok {
+		return author, nil
+	}
+	return Author{}, errAuthorNotFound
+}
+
+// AddAuthor stores author under a new ID, ignoring any ID it already has
+func (s *Catalog) AddAuthor(author Author) (Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addAuthor(author)
+}
+
+// addAuthor is AddAuthor for callers that already hold s.mu
+func (s *Catalog) addAuthor(author Author) (Author, error) {
+	id, err := s.newID()
+	if err != nil {
+		return Author{}, err
+	}
+	author.AuthorId = id
+	s.authors[id] = author
+	return author, nil
+}
+
+// UpdateAuthor applies change to the author with the given id. Courses
+// only hold the author's ID, so they all see the change.
+func (s *Catalog) UpdateAuthor(id string, change func(*Author)) (Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	author, ok := s.authors[id]
+	if !ok {
+		return Author{}, errAuthorNotFound
+	}
+	change(&author)
+	author.AuthorId = id
+	s.authors[id] = author
+	return author, nil
+}
+
+// DeleteAuthor removes an author. An author with courses is only removed
+// when cascade is set, and then their courses go too; the number of
+// courses removed is returned.
+func (s *Catalog) DeleteAuthor(id string, cascade bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.authors[id]; !ok {
+		return 0, errAuthorNotFound
+	}
+	owned := s.index.byAuthor[id]
+	if len(owned) > 0 && !cascade {
+		return 0, errAuthorHasCourses
+	}
+
+	removed := len(owned)
+	for courseID := range owned {
+		s.removeCourse(s.courses[courseID])
+	}
+	delete(s.authors, id)
+	return removed, nil
+}
+
+// CoursesBy returns the courses of an author in creation order
+func (s *Catalog) CoursesBy(authorID string) ([]Course, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.authors[authorID]; !ok {
+		return nil, errAuthorNotFound
+	}
+	var found []Course
+	ids, _ := s.index.byCreated.page(s.index.byAuthor[authorID].orEmpty(), func(id string) string { return id }, false, 0, len(s.courses))
+	for _, id := range ids {
+		found = append(found, s.courses[id])
+	}
+	return found, nil
+}
+
+// ExpandAuthors fills in the Author of each course from its AuthorId
+func (s *Catalog) ExpandAuthors(courses []Course) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range courses {
+		if author, ok := s.authors[courses[i].AuthorId]; ok {
+			courses[i].Author = &author
+		}
+	}
+}
+
+// Coupons returns every coupon ordered by code
+func (s *Catalog) Coupons() []Coupon {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	coupons := make([]Coupon, 0, len(s.coupons))
+	for _, coupon := range s.coupons {
+		coupons = append(coupons, coupon)
+	}
+	slices.SortFunc(coupons, func(a, b Coupon) int { return strings.Compare(a.Code, b.Code) })
+	return coupons
+}
+
+func (s *Catalog) GetCoupon(code string) (Coupon, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if coupon, ok := s.coupons[strings.ToUpper(code)]; ok {
+		return coupon, nil
+	}
+	return Coupon{}, errCouponNotFound
+}
+
+// AddCoupon stores a new coupon; codes are unique regardless of case
+func (s *Catalog) AddCoupon(coupon Coupon) (Coupon, error) {
+	if err := coupon.normalize(); err != nil {
+		return Coupon{}, err
+	}
+	coupon.Uses = 0
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.coupons[coupon.Code]; ok {
+		return Coupon{}, errCouponExists
+	}
+	for _, id := range coupon.CourseIds {
+		if _, ok := s.courses[id]; !ok {
+			return Coupon{}, &ValidationError{"courseids", "names a course that does not exist: " + id}
+		}
+	}
+	s.coupons[coupon.Code] = coupon
+	return coupon, nil
+}
+
+func (s *Catalog) DeleteCoupon(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code = strings.ToUpper(code)
+	if _, ok := s.coupons[code]; !ok {
+		return errCouponNotFound
+	}
+	delete(s.coupons, code)
+	return nil
+}
+
+// Quote prices a course at t with an optional coupon code. Quoting does
+// not use the coupon up; RedeemCoupon does.
+func (s *Catalog) Quote(courseID, code string, t time.Time) (Quote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	course, ok := s.courses[courseID]
+	if !ok {
+		return Quote{}, errCourseNotFound
+	}
+	if code == "" {
+		return quoteCourse(course, nil, t), nil
+	}
+	coupon, ok := s.coupons[strings.ToUpper(code)]
+	if !ok {
+		return Quote{}, &ValidationError{"coupon", "does not exist"}
+	}
+	if err := coupon.usableFor(course, t); err != nil {
+		return Quote{}, err
+	}
+	return quoteCourse(course, &coupon, t), nil
+}
+
+// RedeemCoupon prices a course like Quote and counts one use of the
+// coupon, failing once the coupon is used up
+func (s *Catalog) RedeemCoupon(courseID, code string, t time.Time) (Quote, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	course, ok := s.courses[courseID]
+	if !ok {
+		return Quote{}, errCourseNotFound
+	}
+	return s.redeem(course, code, t)
+}
+
+// redeem prices course with the coupon code and counts one use of it;
+// callers must hold s.mu
+func (s *Catalog) redeem(course Course, code string, t time.Time) (Quote, error) {
+	coupon, ok := s.coupons[strings.ToUpper(code)]
+	if !ok {
+		return Quote{}, &ValidationError{"coupon", "does not exist"}
+	}
+	if err := coupon.usableFor(course, t); err != nil {
+		return Quote{}, err
+	}
+	coupon.Uses++
+	s.coupons[coupon.Code] = coupon
+	return quoteCourse(course, &coupon, t), nil
+}
+
+// newID returns an ID for a new author, course, enrollment, section, lesson
+// or review. The generators do not repeat themselves, but the ID is still
+// checked against every ID handed out before, deleted records' included,
+// so no two records ever share one. Callers must hold s.mu.
+func (s *Catalog) newID() (string, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		id := s.ids.NewID()
+		if !s.issued.has(id) {
+			s.issued[id] = struct{}{}
+			return id, nil
+		}
+	}
+	return "", errIDCollision
+}
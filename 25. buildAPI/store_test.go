@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// sameID hands out one ID over and over, so every ID after the first collides
+type sameID struct{}
+
+func (sameID) NewID() string { return "01HZZZZZZZZZZZZZZZZZZZZZZZ" }
+
+func TestNewIDIsCheckedForEveryKindOfRecord(t *testing.T) {
+	c := NewCatalog(sameID{})
+	course, err := c.Add(Course{CourseName: "Go", CoursePrice: 1000, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Delete(course.CourseId); err != nil {
+		t.Fatal(err)
+	}
+	// the ID stays taken after its record is deleted
+	if _, err := c.Add(Course{CourseName: "Go again", CoursePrice: 1000, Currency: "USD"}); !errors.Is(err, errIDCollision) {
+		t.Errorf("course: %v, want the ID collision", err)
+	}
+
+	c = NewCatalog(&ULIDGenerator{})
+	course, _ = c.Add(Course{CourseName: "Go", CoursePrice: 1000, Currency: "USD"})
+	section, err := c.AddSection(course.CourseId, "Basics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ids = sameID{}
+	c.issued[sameID{}.NewID()] = struct{}{}
+
+	if _, err := c.AddSection(course.CourseId, "More"); !errors.Is(err, errIDCollision) {
+		t.Errorf("section: %v, want the ID collision", err)
+	}
+	if _, err := c.AddLesson(course.CourseId, section.SectionId, Lesson{Title: "Hello", Kind: LessonText, Body: "Hi"}); !errors.Is(err, errIDCollision) {
+		t.Errorf("lesson: %v, want the ID collision", err)
+	}
+	if _, err := c.AddReview(Review{CourseId: course.CourseId, UserId: "ada", Rating: 5}, time.Now()); !errors.Is(err, errIDCollision) {
+		t.Errorf("review: %v, want the ID collision", err)
+	}
+}
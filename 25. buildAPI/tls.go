@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TLSOptions turn on HTTPS for the server. Without a certificate it speaks
+// plain HTTP; with one it serves TLS 1.2 or later with HTTP/2, can send a
+// plain HTTP listener on to it and, given a client CA, takes only clients
+// with a certificate signed by that CA.
+type TLSOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // enables mutual TLS when set
+	RedirectAddr string // plain HTTP listener that redirects to HTTPS
+}
+
+// registerFlags adds the TLS flags to fs
+func (o *TLSOptions) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.CertFile, "tls-cert", "", "TLS certificate file (PEM); enables HTTPS and HTTP/2 (see buildapi gen-cert)")
+	fs.StringVar(&o.KeyFile, "tls-key", "", "TLS private key file (PEM)")
+	fs.StringVar(&o.ClientCAFile, "tls-client-ca", "", "CA file (PEM) for verifying client certificates; enables mutual TLS")
+	fs.StringVar(&o.RedirectAddr, "redirect-addr", "", "address for a plain HTTP listener that redirects to HTTPS")
+}
+
+// check reports options given without the ones they need
+func (o TLSOptions) check() error {
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if o.CertFile == "" && (o.ClientCAFile != "" || o.RedirectAddr != "") {
+		return errors.New("-tls-client-ca and -redirect-addr need -tls-cert and -tls-key")
+	}
+	return nil
+}
+
+// config builds the TLS configuration of the server: HTTP/2 is offered
+// through ALPN, and client certificates are required when a client CA is set
+func (o TLSOptions) config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if o.ClientCAFile == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(o.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", o.ClientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// listen starts server, over TLS when a certificate is set, and the
+// redirect listener when one is asked for. It returns the servers it
+// started; their errors arrive on errs, which needs room for two.
+func (o TLSOptions) listen(server *http.Server, errs chan<- error) ([]*http.Server, error) {
+	if o.CertFile == "" {
+		go func() {
+			fmt.Printf("Server starting on %s...\n", server.Addr)
+			errs <- server.ListenAndServe()
+		}()
+		return []*http.Server{server}, nil
+	}
+
+	config, err := o.config()
+	if err != nil {
+		return nil, err
+	}
+	server.TLSConfig = config
+	mode := "TLS"
+	if o.ClientCAFile != "" {
+		mode = "mutual TLS"
+	}
+	go func() {
+		fmt.Printf("Server starting on %s with %s and HTTP/2...\n", server.Addr, mode)
+		errs <- server.ListenAndServeTLS(o.CertFile, o.KeyFile)
+	}()
+	servers := []*http.Server{server}
+
+	if o.RedirectAddr != "" {
+		redirect := &http.Server{
+			Addr:              o.RedirectAddr,
+			Handler:           redirectToHTTPS(server.Addr),
+			ReadHeaderTimeout: server.ReadHeaderTimeout,
+		}
+		servers = append(servers, redirect)
+		go func() {
+			fmt.Printf("Redirecting HTTP on %s to HTTPS...\n", o.RedirectAddr)
+			errs <- redirect.ListenAndServe()
+		}()
+	}
+	return servers, nil
+}
+
+// redirectToHTTPS sends every request on to the same URL on the HTTPS
+// listener at httpsAddr. 308 keeps the method and body, unlike 301.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		switch {
+		case port != "" && port != "443":
+			host = net.JoinHostPort(host, port)
+		case strings.Contains(host, ":"):
+			host = "[" + host + "]" // an IPv6 address
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// import and export controllers and commands - file
+
+const maxImportBytes = 32 << 20
+
+// importCourses reads a createJson catalogue file from the body.
+// ?currency= (default USD) and ?price_unit=major|minor (default major) say
+// how to read its prices, and ?dry_run=true reports what would happen
+// without storing anything.
+func importCourses(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Import courses")
+	w.Header().Set("Content-Type", "application/json")
+
+	values := r.URL.Query()
+	opts := ImportOptions{Currency: values.Get("currency"), PriceUnit: values.Get("price_unit")}
+	if value := values.Get("dry_run"); value != "" {
+		var err error
+		if opts.DryRun, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+
+	var body json.RawMessage
+	if !decodeJSONLimit(w, r, &body, maxImportBytes) {
+		return
+	}
+	records, err := parseLegacyCourses(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Not a createJson course file: "+err.Error())
+		return
+	}
+
+	report, err := catalog.Import(records, opts)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// exportCourses writes the catalogue in the createJson format; ?currency=
+// keeps only courses priced in it and ?price_unit= works as for import
+func exportCourses(w http.ResponseWriter, r *http.Request) {
+
+	fmt.Println("Export courses")
+	w.Header().Set("Content-Type", "application/json")
+
+	values := r.URL.Query()
+	records, err := catalog.Export(values.Get("currency"), values.Get("price_unit"))
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	encoder.Encode(records)
+}
+
+// runCommand runs gen-cert, or one of the client commands against a
+// running server
+func runCommand(name string, args []string) {
+	var err error
+	switch name {
+	case "import":
+		err = runImport(args)
+	case "export":
+		err = runExport(args)
+	case "gen-cert":
+		err = runGenCert(args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q; commands are import, export and gen-cert\n", name)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// runImport sends a catalogue file, or stdin for "-", to the server's
+// import endpoint and prints the report
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("buildapi import", flag.ContinueOnError)
+	server := fs.String("server", "http://localhost:4000", "base URL of the buildAPI server")
+	currency := fs.String("currency", defaultCurrency, "currency of the prices in the file")
+	priceUnit := fs.String("price-unit", PriceMajor, "unit of the prices in the file: major or minor")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without storing anything")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: buildapi import [flags] file.json")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("import takes one file")
+	}
+
+	var data []byte
+	var err error
+	if fs.Arg(0) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("currency", *currency)
+	query.Set("price_unit", *priceUnit)
+	query.Set("dry_run", strconv.FormatBool(*dryRun))
+	resp, err := http.Post(strings.TrimRight(*server, "/")+"/import?"+query.Encode(), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	var report ImportReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return err
+	}
+	printImportReport(report)
+	return nil
+}
+
+func printImportReport(report ImportReport) {
+	verb := "Imported"
+	if report.DryRun {
+		verb = "Dry run: would import"
+	}
+	fmt.Printf("%s %d of %d courses (%d duplicates, %d invalid); prices in %s %s units\n",
+		verb, report.Created, report.Received, report.Duplicates, report.Invalid, report.Currency, report.PriceUnit)
+	fmt.Printf("Authors: %d new, %d matched by website\n", report.AuthorsCreated, report.AuthorsMatched)
+	for _, item := range report.Items {
+		switch item.Status {
+		case ImportDuplicate:
+			fmt.Printf("  #%d %q: skipped, already in the catalogue\n", item.Index, item.CourseName)
+		case ImportInvalid:
+			fmt.Printf("  #%d %q: skipped, %s\n", item.Index, item.CourseName, item.Error)
+		}
+	}
+}
+
+// runExport fetches the catalogue in the createJson format and writes it
+// to a file, or stdout
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("buildapi export", flag.ContinueOnError)
+	server := fs.String("server", "http://localhost:4000", "base URL of the buildAPI server")
+	currency := fs.String("currency", "", "only export courses priced in this currency")
+	priceUnit := fs.String("price-unit", PriceMajor, "unit to write prices in: major or minor")
+	out := fs.String("o", "", "file to write (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("currency", *currency)
+	query.Set("price_unit", *priceUnit)
+	resp, err := http.Get(strings.TrimRight(*server, "/") + "/export?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	if *out == "" {
+		_, err = io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*out, data, 0o644)
+}
+
+// responseError turns an error response of the server into an error
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error.Message == "" {
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	if body.Error.Field != "" {
+		return fmt.Errorf("server answered %s: %s (%s)", resp.Status, body.Error.Message, body.Error.Field)
+	}
+	return fmt.Errorf("server answered %s: %s", resp.Status, body.Error.Message)
+}